@@ -0,0 +1,348 @@
+package asm
+
+import (
+	"bear/code"
+	"bear/compiler"
+	"bear/object"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Assemble reads a listing in the format Disassemble prints and returns
+// the bytecode it describes, so listings can be written by hand or
+// edited and run again. A listing is made of sections:
+//
+//	== constants ==
+//	0000 fn max               ; index, then an integer, float, quoted
+//	0001 "two"                ; string or fn with the function's name
+//
+//	== main ==
+//	0000 OpConstant 1         ; offset, opcode and operands
+//	L0:                       ; a label jumps can name as their operand
+//
+//	== function 0: max (params 2, locals 2) ==
+//	...                       ; the instructions of constant 0
+//
+// Text after a ; is a comment. The leading indexes and offsets are
+// optional and ignored, so instructions can be inserted without
+// renumbering the listing. Assembled bytecode has no source map.
+func Assemble(source string) (*compiler.Bytecode, error) {
+	asm := &assembler{constants: []object.Object{}, functions: map[int]*section{}}
+
+	for i, text := range strings.Split(source, "\n") {
+		asm.line = i + 1
+		if err := asm.parseLine(text); err != nil {
+			return nil, fmt.Errorf("line %d: %s", asm.line, err)
+		}
+	}
+
+	return asm.bytecode()
+}
+
+var functionHeader = regexp.MustCompile(`^function (\d+): (\S+) \(params (\d+), locals (\d+)\)$`)
+
+type assembler struct {
+	line 		int
+	constants 	[]object.Object
+	main 		*section
+	functions 	map[int]*section // by constant index
+	current 	*section // nil in the constants section
+	started 	bool // whether a section header has been read
+}
+
+// section holds the instructions of the main program or of a function.
+type section struct {
+	line 			int // of the header
+	name 			string
+	instructions 	[]instruction
+	labels 			map[string]int // offsets by name
+	size 			int
+	numParameters 	int
+	numLocals 		int
+}
+
+type instruction struct {
+	line 		int
+	op 			code.Opcode
+	def 		*code.Definition
+	operands 	[]string
+}
+
+func (self *assembler) parseLine(text string) error {
+	fields, err := splitFields(text)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "==") {
+		return self.parseHeader(strings.TrimSpace(strings.Trim(trimmed, "=")))
+	}
+
+	if !self.started {
+		return fmt.Errorf("expected a section header like == main ==")
+	}
+
+	// drop the index or offset the disassembler prints
+	if len(fields) > 1 && isNumber(fields[0]) {
+		fields = fields[1:]
+	}
+
+	if self.current == nil {
+		return self.parseConstant(fields)
+	}
+	return self.parseInstruction(fields)
+}
+
+func (self *assembler) parseHeader(header string) error {
+	self.started = true
+
+	switch {
+	case header == "constants":
+		self.current = nil
+		return nil
+
+	case header == "main":
+		if self.main != nil {
+			return fmt.Errorf("second main section")
+		}
+		self.main = newSection(self.line, "main")
+		self.current = self.main
+		return nil
+	}
+
+	match := functionHeader.FindStringSubmatch(header)
+	if match == nil {
+		return fmt.Errorf("unknown section %q", header)
+	}
+
+	index, _ := strconv.Atoi(match[1])
+	if _, ok := self.functions[index]; ok {
+		return fmt.Errorf("second section for function %d", index)
+	}
+
+	fn := newSection(self.line, match[2])
+	fn.numParameters, _ = strconv.Atoi(match[3])
+	fn.numLocals, _ = strconv.Atoi(match[4])
+	if fn.numLocals < fn.numParameters {
+		return fmt.Errorf("function %d has fewer locals than parameters", index)
+	}
+
+	self.functions[index] = fn
+	self.current = fn
+	return nil
+}
+
+func newSection(line int, name string) *section {
+	return &section{line: line, name: name, labels: map[string]int{}}
+}
+
+func (self *assembler) parseConstant(fields []string) error {
+	value := fields[0]
+
+	var constant object.Object
+	switch {
+	case value == "fn":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected fn and the function's name")
+		}
+		name := fields[1]
+		if name == "<anonymous>" {
+			name = ""
+		}
+		constant = &object.CompiledFunction{Name: name}
+
+	case len(fields) != 1:
+		return fmt.Errorf("unexpected %s after constant", fields[1])
+
+	case strings.HasPrefix(value, `"`):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return fmt.Errorf("invalid string %s", value)
+		}
+		constant = &object.String{Value: s}
+
+	case strings.ContainsAny(value, ".eIN"):
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid float %s", value)
+		}
+		constant = &object.Float{Value: f}
+
+	default:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid constant %s", value)
+		}
+		constant = &object.Integer{Value: n}
+	}
+
+	self.constants = append(self.constants, constant)
+	return nil
+}
+
+func (self *assembler) parseInstruction(fields []string) error {
+	if len(fields) == 1 && strings.HasSuffix(fields[0], ":") {
+		label := strings.TrimSuffix(fields[0], ":")
+		if label == "" || isNumber(label) {
+			return fmt.Errorf("invalid label %q", fields[0])
+		}
+		if _, ok := self.current.labels[label]; ok {
+			return fmt.Errorf("label %s defined twice", label)
+		}
+		self.current.labels[label] = self.current.size
+		return nil
+	}
+
+	op, def, ok := code.LookupName(fields[0])
+	if !ok {
+		return fmt.Errorf("unknown opcode %s", fields[0])
+	}
+
+	operands := fields[1:]
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(operands))
+	}
+
+	self.current.instructions = append(self.current.instructions, instruction{
+		line: 		self.line,
+		op: 		op,
+		def: 		def,
+		operands: 	operands,
+	})
+
+	self.current.size += def.InstructionWidth()
+	return nil
+}
+
+// bytecode encodes the sections once every label is known.
+func (self *assembler) bytecode() (*compiler.Bytecode, error) {
+	bytecode := &compiler.Bytecode{
+		Instructions: 	code.Instructions{},
+		Constants: 		self.constants,
+		SourceMap: 		code.SourceMap{},
+	}
+
+	if self.main != nil {
+		ins, err := self.main.encode()
+		if err != nil {
+			return nil, err
+		}
+		bytecode.Instructions = ins
+	}
+
+	for i, section := range self.functions {
+		if i >= len(self.constants) {
+			return nil, fmt.Errorf("line %d: there is no constant %d", section.line, i)
+		}
+	}
+
+	for i, constant := range self.constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		section := self.functions[i]
+
+		switch {
+		case !ok && section == nil:
+			continue
+		case !ok:
+			return nil, fmt.Errorf("line %d: constant %d is not a function", section.line, i)
+		case section == nil:
+			return nil, fmt.Errorf("function %d has no section", i)
+		}
+
+		if section.name != functionName(fn) {
+			return nil, fmt.Errorf("line %d: function %d is called %s in the constants", section.line, i, functionName(fn))
+		}
+
+		ins, err := section.encode()
+		if err != nil {
+			return nil, err
+		}
+
+		fn.Instructions = ins
+		fn.NumParameters = section.numParameters
+		fn.NumLocals = section.numLocals
+		fn.SourceMap = code.SourceMap{}
+	}
+
+	return bytecode, nil
+}
+
+func (self *section) encode() (code.Instructions, error) {
+	ins := code.Instructions{}
+
+	for _, instruction := range self.instructions {
+		operands := []int{}
+
+		for i, text := range instruction.operands {
+			operand, err := strconv.Atoi(text)
+			if err != nil {
+				offset, ok := self.labels[text]
+				if !ok {
+					return nil, fmt.Errorf("line %d: unknown label %s", instruction.line, text)
+				}
+				operand = offset
+			}
+
+			max := 1 << (8 * instruction.def.OperandWidths[i]) - 1
+			if operand < 0 || operand > max {
+				return nil, fmt.Errorf("line %d: operand %d of %s out of range 0-%d",
+					instruction.line, operand, instruction.def.Name, max)
+			}
+
+			operands = append(operands, operand)
+		}
+
+		ins = append(ins, code.Make(instruction.op, operands...)...)
+	}
+
+	return ins, nil
+}
+
+// splitFields splits a line at spaces, keeping quoted strings whole and
+// dropping comments.
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+
+	i := 0
+	for i < len(line) {
+		switch {
+		case line[i] == ' ' || line[i] == '\t' || line[i] == '\r':
+			i++
+		case line[i] == ';':
+			return fields, nil
+		case line[i] == '"':
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, quoted)
+			i += len(quoted)
+		default:
+			start := i
+			for i < len(line) && !strings.ContainsRune(" \t\r;", rune(line[i])) {
+				i++
+			}
+			fields = append(fields, line[start:i])
+		}
+	}
+
+	return fields, nil
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package asm
+
+import (
+	"bear/code"
+	"bear/compiler"
+	"bear/object"
+	"bear/vm"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	// sums the numbers below 10 with a hand-written loop
+	source := `
+== constants ==
+fn sum
+0
+10
+1
+
+== main ==
+	OpClosure 0 0
+	OpConstant 2     ; 10
+	OpCall 1
+	OpPop
+
+== function 0: sum (params 1, locals 2) ==
+	OpConstant 1
+	OpSetLocal 1     ; total = 0
+loop:
+	OpGetLocal 0
+	OpConstant 1
+	OpGreaterThan
+	OpJumpNotTruthy done
+	OpGetLocal 0
+	OpConstant 3
+	OpSub
+	OpSetLocal 0     ; n = n - 1
+	OpGetLocal 1
+	OpGetLocal 0
+	OpAdd
+	OpSetLocal 1     ; total = total + n
+	OpJump loop
+done:
+	OpGetLocal 1
+	OpReturnValue
+`
+
+	bytecode, err := Assemble(source)
+	if err != nil {
+		t.Fatalf("Assemble failed: %s", err)
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	result, ok := machine.LastPoppedStackElem().(*object.Integer)
+	if !ok || result.Value != 45 {
+		t.Errorf("wrong result. want=45, got=%v", machine.LastPoppedStackElem())
+	}
+}
+
+func TestAssembleConstants(t *testing.T) {
+	bytecode, err := Assemble("== constants ==\n-7\n2.5\n1e+21\n\"a; \\\"b\\\"\"  ; comment\nfn <anonymous>\n" +
+		"== function 4: <anonymous> (params 0, locals 0) ==\nOpReturn\n")
+	if err != nil {
+		t.Fatalf("Assemble failed: %s", err)
+	}
+
+	expected := []object.Object{
+		&object.Integer{Value: -7},
+		&object.Float{Value: 2.5},
+		&object.Float{Value: 1e21},
+		&object.String{Value: `a; "b"`},
+		&object.CompiledFunction{Instructions: code.Make(code.OpReturn), SourceMap: code.SourceMap{}},
+	}
+
+	if !reflect.DeepEqual(bytecode.Constants, expected) {
+		t.Errorf("wrong constants.\nwant=%#v\ngot=%#v", expected, bytecode.Constants)
+	}
+}
+
+// Assembling a listing gives back the bytecode it was disassembled from,
+// apart from the source map.
+func TestDisassembleRoundTrip(t *testing.T) {
+	programs, err := filepath.Glob(filepath.Join("..", "conformance", "testdata", "*.bear"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, program := range programs {
+		source, err := ioutil.ReadFile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bytecode, ok := tryCompile(string(source))
+		if !ok {
+			continue
+		}
+
+		listing := Disassemble(bytecode)
+		assembled, err := Assemble(listing)
+		if err != nil {
+			t.Errorf("%s: Assemble failed: %s\n%s", program, err, listing)
+			continue
+		}
+
+		withoutSourceMaps(bytecode)
+		if !reflect.DeepEqual(assembled, bytecode) {
+			t.Errorf("%s: bytecode changed in the round trip.\nwant=\n%s\ngot=\n%s",
+				program, listing, Disassemble(assembled))
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source 		string
+		expected 	string
+	}{
+		{"OpPop", "line 1: expected a section header"},
+		{"== data ==", `line 1: unknown section "data"`},
+		{"== main ==\nOpNope", "line 2: unknown opcode OpNope"},
+		{"== main ==\nOpConstant", "line 2: OpConstant takes 1 operands, got 0"},
+		{"== main ==\nOpPop 1", "line 2: OpPop takes 0 operands, got 1"},
+		{"== main ==\nOpGetLocal 256", "line 2: operand 256 of OpGetLocal out of range 0-255"},
+		{"== main ==\nOpJump nowhere", "line 2: unknown label nowhere"},
+		{"== main ==\nx:\nx:", "line 3: label x defined twice"},
+		{"== main ==\n== main ==", "line 2: second main section"},
+		{"== constants ==\n\"open", "line 2: unterminated string"},
+		{"== constants ==\n12abc", "line 2: invalid constant 12abc"},
+		{"== constants ==\n1 2 3", "line 2: unexpected 3 after constant"},
+		{"== constants ==\nfn f", "function 0 has no section"},
+		{"== constants ==\n1\n== function 0: f (params 0, locals 0) ==", "line 3: constant 0 is not a function"},
+		{"== function 0: f (params 0, locals 0) ==", "line 1: there is no constant 0"},
+		{"== function 0: f (params 2, locals 1) ==", "line 1: function 0 has fewer locals than parameters"},
+		{"== constants ==\nfn f\n== function 0: g (params 0, locals 0) ==", "line 3: function 0 is called f in the constants"},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble(test.source)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: wrong error. want=%q, got=%v", test.source, test.expected, err)
+		}
+	}
+}
+
+func tryCompile(input string) (*compiler.Bytecode, bool) {
+	p := parserFor(input)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, false
+	}
+
+	comp := compiler.NewWithState(builtinSymbols(), []object.Object{})
+	if err := comp.Compile(program); err != nil {
+		return nil, false
+	}
+	return comp.Bytecode(), true
+}
+
+func withoutSourceMaps(bytecode *compiler.Bytecode) {
+	bytecode.SourceMap = code.SourceMap{}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.SourceMap = code.SourceMap{}
+		}
+	}
+}
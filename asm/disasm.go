@@ -0,0 +1,180 @@
+// Package asm converts compiled bytecode to a readable assembly listing
+// and back.
+package asm
+
+import (
+	"bear/code"
+	"bear/compiler"
+	"bear/object"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// commentColumn is where the comments describing operands start.
+const commentColumn = 28
+
+// Disassemble returns a listing of bytecode: its constant pool, the main
+// program and every compiled function in the pool. Constants are shown
+// next to the instructions that load them and jumps refer to labels.
+func Disassemble(bytecode *compiler.Bytecode) string {
+	var out bytes.Buffer
+
+	if len(bytecode.Constants) > 0 {
+		out.WriteString("== constants ==\n")
+		for i, constant := range bytecode.Constants {
+			fmt.Fprintf(&out, "%04d %s\n", i, formatConstant(constant))
+		}
+		out.WriteString("\n")
+	}
+
+	out.WriteString("== main ==\n")
+	disassemble(&out, bytecode.Instructions, bytecode.Constants)
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n== function %d: %s (params %d, locals %d) ==\n",
+			i, functionName(fn), fn.NumParameters, fn.NumLocals)
+		disassemble(&out, fn.Instructions, bytecode.Constants)
+	}
+
+	return out.String()
+}
+
+// disassemble writes one line per instruction, preceded by a label line
+// where a jump lands.
+func disassemble(out *bytes.Buffer, ins code.Instructions, constants []object.Object) {
+	labels := jumpLabels(ins)
+
+	i := 0
+	for i < len(ins) {
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(out, "%s:\n", label)
+		}
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(out, "%04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+
+		if i + def.InstructionWidth() > len(ins) {
+			fmt.Fprintf(out, "%04d ERROR: truncated %s\n", i, def.Name)
+			return
+		}
+		operands, read := code.ReadOperands(def, ins[i + 1:])
+
+		text := def.Name
+		for j, operand := range operands {
+			if isJump(code.Opcode(ins[i])) && j == 0 {
+				if label, ok := labels[operand]; ok {
+					text += " " + label
+					continue
+				}
+			}
+			text += " " + strconv.Itoa(operand)
+		}
+
+		comment := describe(code.Opcode(ins[i]), operands, constants)
+		if comment == "" {
+			fmt.Fprintf(out, "%04d %s\n", i, text)
+		} else {
+			fmt.Fprintf(out, "%04d %-*s ; %s\n", i, commentColumn - 5, text, comment)
+		}
+
+		i += 1 + read
+	}
+
+	// a jump past the last instruction
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(out, "%s:\n", label)
+	}
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpJumpTruthy
+}
+
+// jumpLabels names the offsets jumps land on L0, L1, ... in the order
+// they appear in ins.
+func jumpLabels(ins code.Instructions) map[int]string {
+	targets := []int{}
+	seen := map[int]bool{}
+
+	i := 0
+	for i < len(ins) {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+
+		if i + def.InstructionWidth() > len(ins) {
+			break
+		}
+		operands, read := code.ReadOperands(def, ins[i + 1:])
+
+		target := -1
+		if isJump(code.Opcode(ins[i])) {
+			target = operands[0]
+		}
+		if target >= 0 && target <= len(ins) && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+
+		i += 1 + read
+	}
+
+	sort.Ints(targets)
+
+	labels := map[int]string{}
+	for n, target := range targets {
+		labels[target] = fmt.Sprintf("L%d", n)
+	}
+	return labels
+}
+
+// describe returns what an instruction's operands refer to, if that is
+// not plain from the numbers.
+func describe(op code.Opcode, operands []int, constants []object.Object) string {
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		if operands[0] < len(constants) {
+			return formatConstant(constants[operands[0]])
+		}
+		return "constant out of range"
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+		return "builtin out of range"
+	}
+	return ""
+}
+
+func formatConstant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.Integer, *object.Float:
+		return obj.Inspect()
+	case *object.CompiledFunction:
+		return "fn " + functionName(obj)
+	default:
+		return fmt.Sprintf("%s %s", obj.Type(), obj.Inspect())
+	}
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
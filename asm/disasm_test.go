@@ -0,0 +1,111 @@
+package asm
+
+import (
+	"bear/code"
+	"bear/compiler"
+	"bear/lexer"
+	"bear/object"
+	"bear/parser"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parserFor(input)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.NewWithState(builtinSymbols(), []object.Object{})
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func parserFor(input string) *parser.Parser {
+	return parser.New(lexer.New(input))
+}
+
+func builtinSymbols() *compiler.SymbolTable {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return symbolTable
+}
+
+func TestDisassemble(t *testing.T) {
+	bytecode := compile(t, `let max = fn(a, b) { if (a > b) { a } else { b } }; puts(max(1, "two"));`)
+
+	expected := `== constants ==
+0000 fn max
+0001 1
+0002 "two"
+
+== main ==
+0000 OpClosure 0 0           ; fn max
+0004 OpSetGlobal 0
+0007 OpGetBuiltin 1          ; puts
+0009 OpGetGlobal 0
+0012 OpConstant 1            ; 1
+0015 OpConstant 2            ; "two"
+0018 OpCall 2
+0020 OpCall 1
+0022 OpPop
+
+== function 0: max (params 2, locals 2) ==
+0000 OpGetLocal 0
+0002 OpGetLocal 1
+0004 OpGreaterThan
+0005 OpJumpNotTruthy L0
+0008 OpGetLocal 0
+0010 OpJump L1
+L0:
+0013 OpGetLocal 1
+L1:
+0015 OpReturnValue
+`
+
+	if got := Disassemble(bytecode); got != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	tests := []struct {
+		bytecode 	*compiler.Bytecode
+		expected 	string
+	}{
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpJump, 3), code.Make(code.OpNull))},
+			"== main ==\n0000 OpJump L0\nL0:\n0003 OpNull\n",
+		},
+		{
+			// a jump to the end and one out of range
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpJump, 6), code.Make(code.OpJump, 99))},
+			"== main ==\n0000 OpJump L0\n0003 OpJump 99\nL0:\n",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpConstant, 7), []byte{255, byte(code.OpConstant), 0})},
+			"== main ==\n0000 OpConstant 7            ; constant out of range\n" +
+				"0003 ERROR: opcode 255 undefined\n0004 ERROR: truncated OpConstant\n",
+		},
+	}
+
+	for _, test := range tests {
+		if got := Disassemble(test.bytecode); got != test.expected {
+			t.Errorf("wrong listing.\nwant=%q\ngot=%q", test.expected, got)
+		}
+	}
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
@@ -3,12 +3,15 @@ package ast
 import (
 	"bear/token"
 	"bytes"
+	"sort"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first character of the node
+	End() token.Position // position immediately after the node
 }
 
 type Statement interface {
@@ -33,14 +36,36 @@ func (self *Program) TokenLiteral() string {
 	}
 }
 
+func (self *Program) Pos() token.Position {
+	if len(self.Statements) > 0 {
+		return self.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (self *Program) End() token.Position {
+	if len(self.Statements) > 0 {
+		return self.Statements[len(self.Statements) - 1].End()
+	}
+	return token.Position{}
+}
+
 func (self *Program) String() string {
 	var out bytes.Buffer
+	writeStatements(&out, self.Statements)
+	return out.String()
+}
 
-	for _, s := range self.Statements {
+// writeStatements writes stmts so that they parse back to the same
+// statements, separating consecutive expression statements with semicolons.
+func writeStatements(out *bytes.Buffer, stmts []Statement) {
+	for i, s := range stmts {
 		out.WriteString(s.String())
-	}
 
-	return out.String()
+		if _, ok := s.(*ExpressionStatement); ok && i < len(stmts) - 1 {
+			out.WriteString("; ")
+		}
+	}
 }
 
 type LetStatement struct {
@@ -51,6 +76,13 @@ type LetStatement struct {
 
 func (self *LetStatement) statementNode() {}
 func (self *LetStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *LetStatement) Pos() token.Position { return self.Token.Pos }
+func (self *LetStatement) End() token.Position {
+	if self.Value != nil {
+		return self.Value.End()
+	}
+	return self.Name.End()
+}
 func (self *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -74,6 +106,8 @@ type Identifier struct {
 
 func (self *Identifier) expressionNode() {}
 func (self *Identifier) TokenLiteral() string { return self.Token.Literal }
+func (self *Identifier) Pos() token.Position { return self.Token.Pos }
+func (self *Identifier) End() token.Position { return self.Token.End }
 func (self *Identifier) String() string { return self.Value }
 
 type ReturnStatement struct {
@@ -83,6 +117,13 @@ type ReturnStatement struct {
 
 func (self *ReturnStatement) statementNode() {}
 func (self *ReturnStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *ReturnStatement) Pos() token.Position { return self.Token.Pos }
+func (self *ReturnStatement) End() token.Position {
+	if self.ReturnValue != nil {
+		return self.ReturnValue.End()
+	}
+	return self.Token.End
+}
 func (self *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -104,6 +145,13 @@ type ExpressionStatement struct {
 
 func (self *ExpressionStatement) statementNode() {}
 func (self *ExpressionStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *ExpressionStatement) Pos() token.Position { return self.Token.Pos }
+func (self *ExpressionStatement) End() token.Position {
+	if self.Expression != nil {
+		return self.Expression.End()
+	}
+	return self.Token.End
+}
 func (self *ExpressionStatement) String() string {
 	if self.Expression != nil {
 		return self.Expression.String()
@@ -119,8 +167,21 @@ type IntegerLiteral struct {
 
 func (self *IntegerLiteral) expressionNode() {}
 func (self *IntegerLiteral) TokenLiteral() string { return self.Token.Literal }
+func (self *IntegerLiteral) Pos() token.Position { return self.Token.Pos }
+func (self *IntegerLiteral) End() token.Position { return self.Token.End }
 func (self *IntegerLiteral) String() string { return self.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (self *FloatLiteral) expressionNode() {}
+func (self *FloatLiteral) TokenLiteral() string { return self.Token.Literal }
+func (self *FloatLiteral) Pos() token.Position { return self.Token.Pos }
+func (self *FloatLiteral) End() token.Position { return self.Token.End }
+func (self *FloatLiteral) String() string { return self.Token.Literal }
+
 type PrefixExpression struct {
 	Token 		token.Token
 	Operator 	string
@@ -129,12 +190,21 @@ type PrefixExpression struct {
 
 func (self *PrefixExpression) expressionNode() {}
 func (self *PrefixExpression) TokenLiteral() string { return self.Token.Literal }
+func (self *PrefixExpression) Pos() token.Position { return self.Token.Pos }
+func (self *PrefixExpression) End() token.Position {
+	if self.Right != nil {
+		return self.Right.End()
+	}
+	return self.Token.End
+}
 func (self *PrefixExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
 	out.WriteString(self.Operator)
-	out.WriteString(self.Right.String())
+	if self.Right != nil {
+		out.WriteString(self.Right.String())
+	}
 	out.WriteString(")")
 
 	return out.String()
@@ -149,13 +219,22 @@ type InfixExpression struct {
 
 func (self *InfixExpression) expressionNode() {}
 func (self *InfixExpression) TokenLiteral() string { return self.Token.Literal }
+func (self *InfixExpression) Pos() token.Position { return self.Left.Pos() }
+func (self *InfixExpression) End() token.Position {
+	if self.Right != nil {
+		return self.Right.End()
+	}
+	return self.Token.End
+}
 func (self *InfixExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
 	out.WriteString(self.Left.String())
 	out.WriteString(" " + self.Operator + " ")
-	out.WriteString(self.Right.String())
+	if self.Right != nil {
+		out.WriteString(self.Right.String())
+	}
 	out.WriteString(")")
 
 	return out.String()
@@ -168,6 +247,8 @@ type Boolean struct {
 
 func (self *Boolean) expressionNode() {}
 func (self *Boolean) TokenLiteral() string { return self.Token.Literal }
+func (self *Boolean) Pos() token.Position { return self.Token.Pos }
+func (self *Boolean) End() token.Position { return self.Token.End }
 func (self *Boolean) String() string { return self.Token.Literal }
 
 type IfExpression struct {
@@ -179,16 +260,26 @@ type IfExpression struct {
 
 func (self *IfExpression) expressionNode() {}
 func (self *IfExpression) TokenLiteral() string { return self.Token.Literal }
+func (self *IfExpression) Pos() token.Position { return self.Token.Pos }
+func (self *IfExpression) End() token.Position {
+	if self.Alternative != nil {
+		return self.Alternative.End()
+	}
+	if self.Consequence != nil {
+		return self.Consequence.End()
+	}
+	return self.Token.End
+}
 func (self *IfExpression) String() string { 
 	var out bytes.Buffer
 
-	out.WriteString("if")
+	out.WriteString("if (")
 	out.WriteString(self.Condition.String())
-	out.WriteString(" ")
+	out.WriteString(") ")
 	out.WriteString(self.Consequence.String())
 
 	if self.Alternative != nil {
-		out.WriteString("else ")
+		out.WriteString(" else ")
 		out.WriteString(self.Alternative.String())
 	}
 
@@ -199,16 +290,19 @@ func (self *IfExpression) String() string {
 type BlockStatement struct {
 	Token token.Token
 	Statements []Statement
+	Rbrace token.Token
 }
 
 func (self *BlockStatement) statementNode() {}
 func (self *BlockStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *BlockStatement) Pos() token.Position { return self.Token.Pos }
+func (self *BlockStatement) End() token.Position { return self.Rbrace.End }
 func (self *BlockStatement) String() string {
 	var out bytes.Buffer
 
-	for _, s := range self.Statements {
-		out.WriteString(s.String())
-	}
+	out.WriteString("{ ")
+	writeStatements(&out, self.Statements)
+	out.WriteString(" }")
 
 	return out.String()
 }
@@ -218,10 +312,18 @@ type FunctionLiteral struct {
 	Token token.Token
 	Parameters []*Identifier
 	Body *BlockStatement
+	Name string // set when the literal is bound by a let statement
 }
 
 func (self *FunctionLiteral) expressionNode() {}
 func (self *FunctionLiteral) TokenLiteral() string { return self.Token.Literal }
+func (self *FunctionLiteral) Pos() token.Position { return self.Token.Pos }
+func (self *FunctionLiteral) End() token.Position {
+	if self.Body != nil {
+		return self.Body.End()
+	}
+	return self.Token.End
+}
 func (self *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -240,13 +342,16 @@ func (self *FunctionLiteral) String() string {
 }
 
 type CallExpression struct {
-	Token token.Token
+	Token token.Token // '(' token
 	Function Expression
 	Arguments []Expression
+	Rparen token.Token
 }
 
 func (self *CallExpression) expressionNode() {}
 func (self *CallExpression) TokenLiteral() string { return self.Token.Literal }
+func (self *CallExpression) Pos() token.Position { return self.Function.Pos() }
+func (self *CallExpression) End() token.Position { return self.Rparen.End }
 func (self *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -270,15 +375,20 @@ type StringLiteral struct {
 
 func (self *StringLiteral) expressionNode() {}
 func (self *StringLiteral) TokenLiteral() string { return self.Token.Literal }
-func (self *StringLiteral) String() string { return self.Token.Literal }
+func (self *StringLiteral) Pos() token.Position { return self.Token.Pos }
+func (self *StringLiteral) End() token.Position { return self.Token.End }
+func (self *StringLiteral) String() string { return `"` + self.Value + `"` }
 
 type ArrayLiteral struct {
 	Token token.Token
 	Elements []Expression
+	Rbracket token.Token
 }
 
 func (self *ArrayLiteral) expressionNode() {}
 func (self *ArrayLiteral) TokenLiteral() string { return self.Token.Literal }
+func (self *ArrayLiteral) Pos() token.Position { return self.Token.Pos }
+func (self *ArrayLiteral) End() token.Position { return self.Rbracket.End }
 func (self *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -295,13 +405,16 @@ func (self *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
+	Token token.Token // '[' token
 	Left Expression
 	Index Expression
+	Rbracket token.Token
 }
 
 func (self *IndexExpression) expressionNode() {}
 func (self *IndexExpression) TokenLiteral() string { return self.Token.Literal }
+func (self *IndexExpression) Pos() token.Position { return self.Left.Pos() }
+func (self *IndexExpression) End() token.Position { return self.Rbracket.End }
 func (self *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -317,16 +430,19 @@ func (self *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token // '{' token
 	Pairs map[Expression]Expression
+	Rbrace token.Token
 }
 
 func (self *HashLiteral) expressionNode() {}
 func (self *HashLiteral) TokenLiteral() string { return self.Token.Literal }
+func (self *HashLiteral) Pos() token.Position { return self.Token.Pos }
+func (self *HashLiteral) End() token.Position { return self.Rbrace.End }
 func (self *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range self.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range self.Keys() {
+		pairs = append(pairs, key.String()+":"+self.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -334,4 +450,123 @@ func (self *HashLiteral) String() string {
 	out.WriteString("}")
 
 	return out.String()
-}
\ No newline at end of file
+}
+
+// Keys returns the keys of the literal in the order they appear in the
+// source, the order in which both engines evaluate the pairs. Keys of a
+// literal built without positions are ordered by their text instead.
+func (self *HashLiteral) Keys() []Expression {
+	keys := []Expression{}
+	for key := range self.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].Pos(), keys[j].Pos()
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+type WhileStatement struct {
+	Token 		token.Token // while token
+	Condition 	Expression
+	Body 		*BlockStatement
+}
+
+func (self *WhileStatement) statementNode() {}
+func (self *WhileStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *WhileStatement) Pos() token.Position { return self.Token.Pos }
+func (self *WhileStatement) End() token.Position {
+	if self.Body != nil {
+		return self.Body.End()
+	}
+	return self.Token.End
+}
+func (self *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while (")
+	out.WriteString(self.Condition.String())
+	out.WriteString(") ")
+	out.WriteString(self.Body.String())
+
+	return out.String()
+}
+
+type ForStatement struct {
+	Token 		token.Token // for token
+	Variable 	*Identifier
+	Iterable 	Expression
+	Body 		*BlockStatement
+}
+
+func (self *ForStatement) statementNode() {}
+func (self *ForStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *ForStatement) Pos() token.Position { return self.Token.Pos }
+func (self *ForStatement) End() token.Position {
+	if self.Body != nil {
+		return self.Body.End()
+	}
+	return self.Token.End
+}
+func (self *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(self.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(self.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(self.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // break token
+}
+
+func (self *BreakStatement) statementNode() {}
+func (self *BreakStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *BreakStatement) Pos() token.Position { return self.Token.Pos }
+func (self *BreakStatement) End() token.Position { return self.Token.End }
+func (self *BreakStatement) String() string { return self.TokenLiteral() + ";" }
+
+type ContinueStatement struct {
+	Token token.Token // continue token
+}
+
+func (self *ContinueStatement) statementNode() {}
+func (self *ContinueStatement) TokenLiteral() string { return self.Token.Literal }
+func (self *ContinueStatement) Pos() token.Position { return self.Token.Pos }
+func (self *ContinueStatement) End() token.Position { return self.Token.End }
+func (self *ContinueStatement) String() string { return self.TokenLiteral() + ";" }
+
+type AssignExpression struct {
+	Token 		token.Token // the assignment operator token
+	Target 		Expression // *Identifier or *IndexExpression
+	Operator 	string
+	Value 		Expression
+}
+
+func (self *AssignExpression) expressionNode() {}
+func (self *AssignExpression) TokenLiteral() string { return self.Token.Literal }
+func (self *AssignExpression) Pos() token.Position { return self.Target.Pos() }
+func (self *AssignExpression) End() token.Position {
+	if self.Value != nil {
+		return self.Value.End()
+	}
+	return self.Token.End
+}
+func (self *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(self.Target.String())
+	out.WriteString(" " + self.Operator + " ")
+	out.WriteString(self.Value.String())
+
+	return out.String()
+}
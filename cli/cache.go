@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"bear/compiler"
+	"bear/vm"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The bytecode cache keeps the compiled form of the scripts bear runs, so
+// running an unchanged script again skips lexing, parsing and compiling.
+// Entries are .bearc files named after a hash of everything the compiled
+// output depends on: the compiler and whether it optimizes, the script's
+// name, which its source positions mention, and its source text. Bear
+// has no imports, so a script's own text is all of its source.
+
+// cacheDirEnv names the environment variable that overrides the cache
+// directory. Setting it to "off" disables the cache.
+const cacheDirEnv = "BEAR_CACHE_DIR"
+
+// cacheDir returns the directory cached bytecode is kept in, or "" if
+// there is none.
+func cacheDir() string {
+	if dir, ok := os.LookupEnv(cacheDirEnv); ok {
+		if dir == "off" {
+			return ""
+		}
+		return dir
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bear")
+}
+
+// cacheKey identifies the bytecode compiled from source.
+func cacheKey(filename string, source string, optimize bool) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "bearc %d\ncompiler %s\noptimize %t\n", compiler.FormatVersion, compiler.Version, optimize)
+	fmt.Fprintf(hash, "%d:%s\n", len(filename), filename)
+	hash.Write([]byte(source))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func cachePath(dir string, filename string, source string, optimize bool) string {
+	return filepath.Join(dir, cacheKey(filename, source, optimize) + ".bearc")
+}
+
+// loadCached returns the cached bytecode for source, if there is a valid
+// entry for it.
+func loadCached(dir string, filename string, source string, optimize bool) (*compiler.Bytecode, bool) {
+	data, err := ioutil.ReadFile(cachePath(dir, filename, source, optimize))
+	if err != nil {
+		return nil, false
+	}
+
+	bytecode := &compiler.Bytecode{}
+	if err := bytecode.UnmarshalBinary(data); err != nil {
+		return nil, false
+	}
+	if err := vm.Verify(bytecode); err != nil {
+		return nil, false
+	}
+	return bytecode, true
+}
+
+// storeCached adds bytecode to the cache. The cache is only an
+// optimization, so failing to write it is not an error. The entry is
+// written to a temporary file first, so concurrent runs never see half
+// of it.
+func storeCached(dir string, filename string, source string, optimize bool, bytecode *compiler.Bytecode) {
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(dir, "tmp-*.bearc")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cachePath(dir, filename, source, optimize))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
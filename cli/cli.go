@@ -0,0 +1,464 @@
+// Package cli implements the bear command: running scripts, the REPL and
+// the tools that inspect a script without running it.
+package cli
+
+import (
+	"bear/asm"
+	"bear/ast"
+	"bear/compiler"
+	"bear/evaluator"
+	"bear/lexer"
+	"bear/object"
+	"bear/parser"
+	"bear/repl"
+	"bear/vm"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Exit statuses of the bear command.
+const (
+	ExitOK 				= 0
+	ExitFailure 		= 1 // runtime error, or the script could not be read
+	ExitUsage 			= 2
+	ExitCompileError 	= 3 // syntax or compile error
+)
+
+const usage = `usage: bear <command> [arguments]
+
+commands:
+  run [flags] <file> [args...]   run a script or a compiled .bearc file,
+                                 - reads it from stdin
+  repl [flags]                   start the interactive prompt
+  compile [-O] [-o <out>] <file> compile a script to a .bearc file
+  disasm [-O] <file>             print the bytecode a script compiles to, or
+                                 the bytecode in a .bearc file
+  asm [-o <out>] <file>          assemble a listing like disasm prints to a
+                                 .bearc file
+  check <file>...                report errors in scripts without running them
+
+bear <file> [args...] is short for bear run <file> [args...].
+The arguments after the script are available to it as the array args.
+
+flags:
+  --engine=vm|eval     run on the bytecode VM (default) or the tree-walking
+                       evaluator
+  --check-overflow     report integer overflow as a runtime error (run only)
+  --no-cache           compile the script even if the bytecode cache has it
+                       (run only)
+  -O                   fold constant expressions and drop if branches that
+                       never run (run, compile and disasm)
+
+Compiled scripts are cached in the user cache directory, or in
+$BEAR_CACHE_DIR if it is set; BEAR_CACHE_DIR=off disables the cache.
+`
+
+const BEAR_TEXT = `
+██████╗ ███████╗ █████╗ ██████╗ 
+██╔══██╗██╔════╝██╔══██╗██╔══██╗
+██████╔╝█████╗  ███████║██████╔╝
+██╔══██╗██╔══╝  ██╔══██║██╔══██╗
+██████╔╝███████╗██║  ██║██║  ██║
+╚═════╝ ╚══════╝╚═╝  ╚═╝╚═╝  ╚═╝
+`
+
+// Main runs the bear command with args, not including the program name,
+// and returns its exit status.
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return startRepl(repl.ENGINE_VM, stdin, stdout, stderr)
+	}
+
+	switch args[0] {
+	case "run":
+		return run(args[1:], stdin, stdout, stderr)
+	case "repl":
+		flags := newFlagSet("repl", stderr)
+		engine := engineFlag(flags)
+		if err := flags.Parse(args[1:]); err != nil {
+			return ExitUsage
+		}
+		if flags.NArg() != 0 {
+			fmt.Fprintf(stderr, "bear repl: unexpected argument %s\n\n%s", flags.Arg(0), usage)
+			return ExitUsage
+		}
+		return startRepl(*engine, stdin, stdout, stderr)
+	case "compile":
+		flags := newFlagSet("compile", stderr)
+		optimize := optimizeFlag(flags)
+		return compileFile(flags, args[1:], stdin, stderr, func(filename string, source string) (*compiler.Bytecode, bool) {
+			return parseAndCompile(filename, source, *optimize, stderr)
+		})
+	case "asm":
+		return compileFile(newFlagSet("asm", stderr), args[1:], stdin, stderr, func(filename string, source string) (*compiler.Bytecode, bool) {
+			bytecode, err := asm.Assemble(source)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %s\n", filename, err)
+				return nil, false
+			}
+			return bytecode, true
+		})
+	case "disasm":
+		return disasm(args[1:], stdin, stdout, stderr)
+	case "check":
+		return check(args[1:], stdin, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return ExitOK
+	}
+
+	if strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		fmt.Fprintf(stderr, "bear: unknown command %s\n\n%s", args[0], usage)
+		return ExitUsage
+	}
+
+	// a script run directly, for example through a shebang line
+	return run(args, stdin, stdout, stderr)
+}
+
+func startRepl(engine string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if !validEngine(engine, stderr) {
+		return ExitUsage
+	}
+
+	username := "friend"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	fmt.Fprint(stdout, BEAR_TEXT)
+	fmt.Fprintf(stdout, "Welcome to the Bear Programming language, %s", username)
+	fmt.Fprintf(stdout, "\nType in commands\n")
+	repl.Start(stdin, stdout, engine)
+	return ExitOK
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("run", stderr)
+	engine := engineFlag(flags)
+	checkOverflow := flags.Bool("check-overflow", false, "report integer overflow as a runtime error")
+	noCache := flags.Bool("no-cache", false, "do not use the bytecode cache")
+	optimize := optimizeFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(stderr, "bear run: no script given\n\n%s", usage)
+		return ExitUsage
+	}
+
+	if !validEngine(*engine, stderr) {
+		return ExitUsage
+	}
+
+	filename := flags.Arg(0)
+	source, err := readSource(filename, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "bear: %s\n", err)
+		return ExitFailure
+	}
+
+	object.Output = stdout
+	arguments := flags.Args()[1:]
+
+	if compiler.IsSerialized([]byte(source)) {
+		if *engine == repl.ENGINE_EVAL {
+			fmt.Fprintf(stderr, "bear: %s is compiled bytecode, which only the vm engine runs\n", filename)
+			return ExitUsage
+		}
+
+		bytecode, ok := loadBytecode(filename, source, stderr)
+		if !ok {
+			return ExitFailure
+		}
+		if err := vm.Verify(bytecode); err != nil {
+			fmt.Fprintf(stderr, "bear: %s: invalid bytecode: %s\n", filename, err)
+			return ExitFailure
+		}
+		return runBytecode(bytecode, arguments, *checkOverflow, stderr)
+	}
+
+	cache := ""
+	if *engine == repl.ENGINE_VM && !*noCache {
+		cache = cacheDir()
+	}
+
+	if cache != "" {
+		if bytecode, ok := loadCached(cache, filename, source, *optimize); ok {
+			return runBytecode(bytecode, arguments, *checkOverflow, stderr)
+		}
+	}
+
+	program, ok := parse(filename, source, stderr)
+	if !ok {
+		return ExitCompileError
+	}
+
+	// the evaluator runs the program as it is, but one the compiler
+	// rejects fails the same way on both engines
+	bytecode, ok := compile(program, *optimize, stderr)
+	if !ok {
+		return ExitCompileError
+	}
+
+	if *engine == repl.ENGINE_EVAL {
+		return runEval(program, arguments, *checkOverflow, stderr)
+	}
+
+	if cache != "" {
+		storeCached(cache, filename, source, *optimize, bytecode)
+	}
+	return runBytecode(bytecode, arguments, *checkOverflow, stderr)
+}
+
+func runBytecode(bytecode *compiler.Bytecode, args []string, checkOverflow bool, stderr io.Writer) int {
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[argsIndex] = scriptArgs(args)
+
+	machine := vm.NewWithGlobalsStore(bytecode, globals)
+	machine.CheckOverflow = checkOverflow
+
+	err := machine.Run()
+	if err != nil {
+		printRuntimeError(stderr, err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+func runEval(program *ast.Program, args []string, checkOverflow bool, stderr io.Writer) int {
+	env := object.NewEnvironment()
+	env.Set("args", scriptArgs(args))
+
+	eval := evaluator.New()
+	eval.CheckOverflow = checkOverflow
+
+	result := eval.Eval(program, env)
+	if err, ok := result.(*object.Error); ok {
+		printRuntimeError(stderr, err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+func disasm(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("disasm", stderr)
+	optimize := optimizeFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "bear disasm: expected one script\n\n%s", usage)
+		return ExitUsage
+	}
+
+	filename := flags.Arg(0)
+	source, err := readSource(filename, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "bear: %s\n", err)
+		return ExitFailure
+	}
+
+	var bytecode *compiler.Bytecode
+	var ok bool
+	if compiler.IsSerialized([]byte(source)) {
+		if bytecode, ok = loadBytecode(filename, source, stderr); !ok {
+			return ExitFailure
+		}
+	} else if bytecode, ok = parseAndCompile(filename, source, *optimize, stderr); !ok {
+		return ExitCompileError
+	}
+
+	io.WriteString(stdout, asm.Disassemble(bytecode))
+	return ExitOK
+}
+
+// compileFile writes the bytecode build makes of a file to a .bearc file;
+// build prints its own errors.
+func compileFile(flags *flag.FlagSet, args []string, stdin io.Reader, stderr io.Writer,
+	build func(filename string, source string) (*compiler.Bytecode, bool)) int {
+	command := flags.Name()
+	output := flags.String("o", "", "file to write the bytecode to")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "bear %s: expected one file\n\n%s", command, usage)
+		return ExitUsage
+	}
+
+	filename := flags.Arg(0)
+	if *output == "" {
+		if filename == "-" {
+			fmt.Fprintf(stderr, "bear %s: -o is required when reading stdin\n", command)
+			return ExitUsage
+		}
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".bearc"
+	}
+
+	source, err := readSource(filename, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "bear: %s\n", err)
+		return ExitFailure
+	}
+
+	bytecode, ok := build(filename, source)
+	if !ok {
+		return ExitCompileError
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err == nil {
+		err = ioutil.WriteFile(*output, data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "bear: %s\n", err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+func check(args []string, stdin io.Reader, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "bear check: no scripts given\n\n%s", usage)
+		return ExitUsage
+	}
+
+	status := ExitOK
+	for _, filename := range args {
+		source, err := readSource(filename, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "bear: %s\n", err)
+			status = ExitFailure
+			continue
+		}
+
+		if _, ok := parseAndCompile(filename, source, false, stderr); !ok && status == ExitOK {
+			status = ExitCompileError
+		}
+	}
+
+	return status
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+func engineFlag(flags *flag.FlagSet) *string {
+	return flags.String("engine", repl.ENGINE_VM, "engine to run on, vm or eval")
+}
+
+func optimizeFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("O", false, "optimize the bytecode")
+}
+
+func validEngine(engine string, stderr io.Writer) bool {
+	if engine != repl.ENGINE_VM && engine != repl.ENGINE_EVAL {
+		fmt.Fprintf(stderr, "bear: unknown engine %q, want %s or %s\n", engine, repl.ENGINE_VM, repl.ENGINE_EVAL)
+		return false
+	}
+	return true
+}
+
+func readSource(filename string, stdin io.Reader) (string, error) {
+	var source []byte
+	var err error
+
+	if filename == "-" {
+		source, err = ioutil.ReadAll(stdin)
+	} else {
+		source, err = ioutil.ReadFile(filename)
+	}
+
+	return string(source), err
+}
+
+// argsIndex is the global slot holding the script arguments; args is the
+// first global every script defines.
+const argsIndex = 0
+
+func newSymbolTable() *compiler.SymbolTable {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	symbolTable.Define("args")
+	return symbolTable
+}
+
+func scriptArgs(args []string) *object.Array {
+	elements := []object.Object{}
+	for _, arg := range args {
+		elements = append(elements, &object.String{Value: arg})
+	}
+	return &object.Array{Elements: elements}
+}
+
+// parse parses source, printing any errors to stderr.
+func parse(filename string, source string, stderr io.Writer) (*ast.Program, bool) {
+	lex := lexer.NewFile(filename, source)
+	par := parser.New(lex)
+
+	program := par.ParseProgram()
+	if len(par.Errors()) != 0 {
+		for _, msg := range par.Errors() {
+			fmt.Fprintln(stderr, msg)
+		}
+		return nil, false
+	}
+
+	return program, true
+}
+
+// compile compiles program, printing any error to stderr.
+func compile(program *ast.Program, optimize bool, stderr io.Writer) (*compiler.Bytecode, bool) {
+	comp := compiler.NewWithState(newSymbolTable(), []object.Object{})
+	comp.Optimize = optimize
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return nil, false
+	}
+
+	return comp.Bytecode(), true
+}
+
+// loadBytecode loads serialized bytecode, printing any error to stderr.
+func loadBytecode(filename string, source string, stderr io.Writer) (*compiler.Bytecode, bool) {
+	bytecode := &compiler.Bytecode{}
+	if err := bytecode.UnmarshalBinary([]byte(source)); err != nil {
+		fmt.Fprintf(stderr, "bear: %s: %s\n", filename, err)
+		return nil, false
+	}
+	return bytecode, true
+}
+
+func parseAndCompile(filename string, source string, optimize bool, stderr io.Writer) (*compiler.Bytecode, bool) {
+	program, ok := parse(filename, source, stderr)
+	if !ok {
+		return nil, false
+	}
+	return compile(program, optimize, stderr)
+}
+
+func printRuntimeError(out io.Writer, err error) {
+	if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+		io.WriteString(out, runtimeErr.Backtrace())
+		return
+	}
+	fmt.Fprintln(out, err)
+}
@@ -0,0 +1,590 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type cliTestCase struct {
+	args 		[]string
+	script 		string
+	stdin 		string
+	status 		int
+	stdout 		string
+	stderr 		string // substring expected on stderr
+}
+
+func runCliTests(t *testing.T, tests []cliTestCase) {
+	t.Helper()
+
+	for _, test := range tests {
+		args := make([]string, len(test.args))
+		for i, arg := range test.args {
+			if arg == "SCRIPT" {
+				arg = writeScript(t, test.script)
+			}
+			args[i] = arg
+		}
+
+		var stdout, stderr bytes.Buffer
+		status := Main(args, strings.NewReader(test.stdin), &stdout, &stderr)
+
+		if status != test.status {
+			t.Errorf("%v: wrong exit status. want=%d, got=%d (stderr %q)",
+				test.args, test.status, status, stderr.String())
+		}
+
+		if stdout.String() != test.stdout {
+			t.Errorf("%v: wrong stdout. want=%q, got=%q", test.args, test.stdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%v: stderr does not contain %q. got=%q", test.args, test.stderr, stderr.String())
+		}
+	}
+}
+
+func writeScript(t *testing.T, source string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "bear")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "script.bear")
+	err = ioutil.WriteFile(filename, []byte(source), 0644)
+	if err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+
+	return filename
+}
+
+func TestRun(t *testing.T) {
+	tests := []cliTestCase{
+		{
+			args:   []string{"run", "SCRIPT"},
+			script: `puts("hello"); puts(1 + 2);`,
+			status: ExitOK,
+			stdout: "hello\n3\n",
+		},
+		{
+			args:   []string{"SCRIPT"},
+			script: `puts("direct");`,
+			status: ExitOK,
+			stdout: "direct\n",
+		},
+		{
+			args:   []string{"run", "-"},
+			stdin:  `puts("from stdin");`,
+			status: ExitOK,
+			stdout: "from stdin\n",
+		},
+		{
+			args:   []string{"SCRIPT"},
+			script: "#!/usr/bin/env bear\nputs(\"shebang\");",
+			status: ExitOK,
+			stdout: "shebang\n",
+		},
+	}
+
+	runCliTests(t, tests)
+}
+
+func TestScriptArguments(t *testing.T) {
+	script := `puts(len(args)); for (a in args) { puts(a); }`
+
+	tests := []cliTestCase{
+		{
+			args:   []string{"run", "SCRIPT"},
+			script: script,
+			status: ExitOK,
+			stdout: "0\n",
+		},
+		{
+			args:   []string{"run", "SCRIPT", "one", "--two", "3"},
+			script: script,
+			status: ExitOK,
+			stdout: "3\none\n--two\n3\n",
+		},
+		{
+			args:   []string{"SCRIPT", "x"},
+			script: `let f = fn() { args[0] }; puts(f());`,
+			status: ExitOK,
+			stdout: "x\n",
+		},
+	}
+
+	runCliTests(t, tests)
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []cliTestCase{
+		{
+			args:   []string{"run", "SCRIPT"},
+			script: `let x = ;`,
+			status: ExitCompileError,
+			stderr: "script.bear:1:",
+		},
+		{
+			args:   []string{"run", "SCRIPT"},
+			script: `puts(undefined);`,
+			status: ExitCompileError,
+			stderr: "identifier not found: undefined",
+		},
+		{
+			args:   []string{"run", "SCRIPT"},
+			script: "puts(\"before\");\n1 / 0;",
+			status: ExitFailure,
+			stdout: "before\n",
+			stderr: "division by zero",
+		},
+		{
+			args:   []string{"run", "--check-overflow", "SCRIPT"},
+			script: `9223372036854775807 + 1;`,
+			status: ExitFailure,
+			stderr: "integer overflow",
+		},
+		{
+			args:   []string{"run", "SCRIPT"},
+			script: `9223372036854775807 + 1;`,
+			status: ExitOK,
+		},
+		{
+			args:   []string{"run", "does-not-exist.bear"},
+			status: ExitFailure,
+			stderr: "does-not-exist.bear",
+		},
+	}
+
+	runCliTests(t, tests)
+}
+
+func TestUsage(t *testing.T) {
+	tests := []cliTestCase{
+		{
+			args:   []string{"help"},
+			status: ExitOK,
+			stdout: usage,
+		},
+		{
+			args:   []string{"run"},
+			status: ExitUsage,
+			stderr: "no script given",
+		},
+		{
+			args:   []string{"run", "--no-such-flag", "x.bear"},
+			status: ExitUsage,
+			stderr: "no-such-flag",
+		},
+		{
+			args:   []string{"--no-such-flag"},
+			status: ExitUsage,
+			stderr: "unknown command --no-such-flag",
+		},
+		{
+			args:   []string{"disasm"},
+			status: ExitUsage,
+			stderr: "expected one script",
+		},
+		{
+			args:   []string{"check"},
+			status: ExitUsage,
+			stderr: "no scripts given",
+		},
+	}
+
+	runCliTests(t, tests)
+}
+
+func TestCheck(t *testing.T) {
+	tests := []cliTestCase{
+		{
+			args:   []string{"check", "SCRIPT"},
+			script: `let add = fn(a, b) { a + b }; puts(add(1, 2));`,
+			status: ExitOK,
+		},
+		{
+			args:   []string{"check", "SCRIPT"},
+			script: `let x = 1 +;`,
+			status: ExitCompileError,
+			stderr: "script.bear:1:",
+		},
+		{
+			// check never runs the script
+			args:   []string{"check", "SCRIPT"},
+			script: `puts("ran"); 1 / 0;`,
+			status: ExitOK,
+		},
+	}
+
+	runCliTests(t, tests)
+}
+
+func TestDisasm(t *testing.T) {
+	script := `let add = fn(a, b) { a + b };`
+
+	var stdout, stderr bytes.Buffer
+	status := Main([]string{"disasm", writeScript(t, script)}, strings.NewReader(""), &stdout, &stderr)
+	if status != ExitOK {
+		t.Fatalf("wrong exit status. want=%d, got=%d (stderr %q)", ExitOK, status, stderr.String())
+	}
+
+	expected := []string{
+		"== constants ==\n0000 fn add\n",
+		"== main ==\n",
+		"OpClosure 0 0           ; fn add\n",
+		"OpSetGlobal 1\n",
+		"== function 0: add (params 2, locals 2) ==\n",
+		"OpGetLocal 0\n",
+		"OpAdd\n",
+		"OpReturnValue\n",
+	}
+
+	output := stdout.String()
+	for _, want := range expected {
+		if !strings.Contains(output, want) {
+			t.Errorf("disasm output does not contain %q. got=\n%s", want, output)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	script := "puts(2 * 21);\nif (false) { puts(\"never\"); }\n"
+
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"run", "-O", "SCRIPT"},
+			script: script,
+			status: ExitOK,
+			stdout: "42\n",
+		},
+		{
+			args:   []string{"disasm", "-O", "SCRIPT"},
+			script: script,
+			status: ExitOK,
+			stdout: "== constants ==\n0000 42\n\n== main ==\n" +
+				"0000 OpGetBuiltin 1          ; puts\n" +
+				"0002 OpConstant 0            ; 42\n" +
+				"0005 OpCall 1\n" +
+				"0007 OpPop\n" +
+				"0008 OpNull\n" +
+				"0009 OpPop\n",
+		},
+	})
+}
+
+func TestEngines(t *testing.T) {
+	for _, engine := range []string{"vm", "eval"} {
+		flag := "--engine=" + engine
+
+		tests := []cliTestCase{
+			{
+				args:   []string{"run", flag, "SCRIPT", "a", "b"},
+				script: `let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; puts(fib(10)); puts(args);`,
+				status: ExitOK,
+				stdout: "55\n[a, b]\n",
+			},
+			{
+				args:   []string{"run", flag, "SCRIPT"},
+				script: "puts(\"before\");\nlet x = 1 / 0;",
+				status: ExitFailure,
+				stdout: "before\n",
+				stderr: "script.bear:2:11: division by zero",
+			},
+			{
+				args:   []string{"run", flag, "--check-overflow", "SCRIPT"},
+				script: `9223372036854775807 * 2;`,
+				status: ExitFailure,
+				stderr: "integer overflow",
+			},
+			{
+				args:   []string{"run", flag, "SCRIPT"},
+				script: `let x = (1;`,
+				status: ExitCompileError,
+				stderr: "script.bear:1:",
+			},
+			{
+				args:   []string{"run", flag, "SCRIPT"},
+				script: "puts(\"before\");\nputs(y);",
+				status: ExitCompileError,
+				stderr: "script.bear:2:6: identifier not found: y",
+			},
+		}
+
+		runCliTests(t, tests)
+	}
+
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"run", "--engine=jit", "SCRIPT"},
+			status: ExitUsage,
+			stderr: `unknown engine "jit"`,
+		},
+		{
+			args:   []string{"repl", "--engine=jit"},
+			status: ExitUsage,
+			stderr: `unknown engine "jit"`,
+		},
+	})
+}
+
+func TestReplEngines(t *testing.T) {
+	input := "let x = 5;\nx * 2\n1 / 0\n:disasm let y = x + 1;\n:disasm\n" +
+		"let z = 1 / 0;\nz + 1\nif (false) { let w = 1 }; puts(w);\nw\nx + 10\n"
+
+	for _, engine := range []string{"vm", "eval"} {
+		var stdout, stderr bytes.Buffer
+		status := Main([]string{"repl", "--engine=" + engine}, strings.NewReader(input), &stdout, &stderr)
+		if status != ExitOK {
+			t.Fatalf("%s: wrong exit status. want=%d, got=%d", engine, ExitOK, status)
+		}
+
+		output := stdout.String()
+		expected := []string{
+			">> 10\n",
+			"Whoops! Execution failed:\n1:3: division by zero\n",
+			"usage: :disasm <code>\n",
+			// a let that failed or never ran does not define its name
+			"1:1: identifier not found: z\n",
+			"1:1: identifier not found: w\n",
+			">> 15\n",
+		}
+		if engine == "vm" {
+			expected = append(expected, "0000 OpGetGlobal 0\n0003 OpConstant 4            ; 1\n0006 OpAdd\n0007 OpSetGlobal 1\n")
+		} else {
+			// the compiler does not see what the evaluator defined
+			expected = append(expected, "identifier not found: x\n")
+		}
+
+		for _, want := range expected {
+			if !strings.Contains(output, want) {
+				t.Errorf("%s: repl output does not contain %q. got=\n%s", engine, want, output)
+			}
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	script := writeScript(t, "let greet = fn(name) { \"hello \" + name };\nputs(greet(args[0]));\nlet x = 1 / 0;")
+	compiled := strings.TrimSuffix(script, ".bear") + ".bearc"
+
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"compile", script},
+			status: ExitOK,
+		},
+		{
+			args:   []string{"run", compiled, "bear"},
+			status: ExitFailure,
+			stdout: "hello bear\n",
+			stderr: "script.bear:3:11: division by zero",
+		},
+		{
+			args:   []string{compiled, "again"},
+			status: ExitFailure,
+			stdout: "hello again\n",
+		},
+		{
+			args:   []string{"run", "--engine=eval", compiled},
+			status: ExitUsage,
+			stderr: "only the vm engine runs",
+		},
+		{
+			args:   []string{"compile", "SCRIPT"},
+			script: `let x = ;`,
+			status: ExitCompileError,
+			stderr: "script.bear:1:",
+		},
+		{
+			args:   []string{"compile"},
+			status: ExitUsage,
+			stderr: "expected one file",
+		},
+		{
+			args:   []string{"compile", "-"},
+			status: ExitUsage,
+			stderr: "-o is required",
+		},
+	})
+
+	var stdout, stderr bytes.Buffer
+	if status := Main([]string{"disasm", compiled}, strings.NewReader(""), &stdout, &stderr); status != ExitOK {
+		t.Fatalf("disasm: wrong exit status. want=%d, got=%d (stderr %q)", ExitOK, status, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "== function 1: greet (params 1, locals 1) ==") {
+		t.Errorf("disasm output does not contain the function. got=\n%s", stdout.String())
+	}
+
+	output := filepath.Join(filepath.Dir(script), "out.bearc")
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"compile", "-o", output, "-"},
+			stdin:  `puts("from stdin");`,
+			status: ExitOK,
+		},
+		{
+			args:   []string{"run", output},
+			status: ExitOK,
+			stdout: "from stdin\n",
+		},
+	})
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data) - 1] ^= 0xff
+	if err := ioutil.WriteFile(output, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"run", output},
+			status: ExitFailure,
+			stderr: "checksum mismatch",
+		},
+	})
+}
+
+func TestMain(m *testing.M) {
+	// keep the tests out of the user's bytecode cache
+	dir, err := ioutil.TempDir("", "bear-cache")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(cacheDirEnv, dir)
+
+	status := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(status)
+}
+
+func cacheEntries(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := filepath.Glob(filepath.Join(dir, "*.bearc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestBytecodeCache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(cacheDirEnv, dir)
+
+	script := writeScript(t, `puts("compiled");`)
+	run := cliTestCase{args: []string{"run", script}, status: ExitOK, stdout: "compiled\n"}
+
+	runCliTests(t, []cliTestCase{run})
+
+	entries := cacheEntries(t, dir)
+	if len(entries) != 1 {
+		t.Fatalf("wrong number of cache entries. want=1, got=%d", len(entries))
+	}
+
+	// swap in the bytecode of another program to see the entry is used
+	other, _ := parseAndCompile("other.bear", `puts("from cache");`, false, ioutil.Discard)
+	data, err := other.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(entries[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runCliTests(t, []cliTestCase{
+		{args: []string{"run", script}, status: ExitOK, stdout: "from cache\n"},
+		{args: []string{"run", "--no-cache", script}, status: ExitOK, stdout: "compiled\n"},
+		{args: []string{"run", "--engine=eval", script}, status: ExitOK, stdout: "compiled\n"},
+	})
+
+	// a corrupt entry is recompiled and replaced
+	if err := ioutil.WriteFile(entries[0], []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runCliTests(t, []cliTestCase{run, run})
+
+	if err := ioutil.WriteFile(script, []byte(`puts("changed");`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := cliTestCase{args: []string{"run", script}, status: ExitOK, stdout: "changed\n"}
+	runCliTests(t, []cliTestCase{changed})
+
+	if got := len(cacheEntries(t, dir)); got != 2 {
+		t.Errorf("wrong number of cache entries. want=2, got=%d", got)
+	}
+
+	os.RemoveAll(dir)
+	t.Setenv(cacheDirEnv, "off")
+	runCliTests(t, []cliTestCase{changed})
+
+	if got := len(cacheEntries(t, dir)); got != 0 {
+		t.Errorf("disabled cache has entries. got=%d", got)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	key := cacheKey("a.bear", "puts(1);", false)
+
+	if cacheKey("a.bear", "puts(1);", false) != key {
+		t.Errorf("cache key is not stable")
+	}
+	if cacheKey("b.bear", "puts(1);", false) == key {
+		t.Errorf("cache key does not depend on the file name")
+	}
+	if cacheKey("a.bear", "puts(2);", false) == key {
+		t.Errorf("cache key does not depend on the source")
+	}
+	if cacheKey("a.bear", "puts(1);", true) == key {
+		t.Errorf("cache key does not depend on optimization")
+	}
+}
+
+func TestAsm(t *testing.T) {
+	listing := writeScript(t, "== constants ==\n\"assembled\"\n\n== main ==\nOpGetBuiltin 1\nOpConstant 0\nOpCall 1\nOpPop\n")
+	compiled := strings.TrimSuffix(listing, ".bear") + ".bearc"
+
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"asm", listing},
+			status: ExitOK,
+		},
+		{
+			args:   []string{"run", compiled},
+			status: ExitOK,
+			stdout: "assembled\n",
+		},
+		{
+			args:   []string{"asm", "SCRIPT"},
+			script: "== main ==\nOpNope",
+			status: ExitCompileError,
+			stderr: "script.bear: line 2: unknown opcode OpNope",
+		},
+	})
+}
+
+func TestRunVerifiesBytecode(t *testing.T) {
+	listing := writeScript(t, "== main ==\nOpGetLocal 0\nOpPop\n")
+	compiled := strings.TrimSuffix(listing, ".bear") + ".bearc"
+
+	runCliTests(t, []cliTestCase{
+		{
+			args:   []string{"asm", listing},
+			status: ExitOK,
+		},
+		{
+			args:   []string{"run", compiled},
+			status: ExitFailure,
+			stderr: "script.bearc: invalid bytecode: main at 0000: local 0 out of range (0 locals)",
+		},
+	})
+}
@@ -4,15 +4,27 @@ import (
 	"encoding/binary"
 	"fmt"
 	"bytes"
+	"bear/token"
 )
 
 type Instructions []byte
 
+// SourceMap maps the offset of an instruction to the source position
+// it was compiled from.
+type SourceMap map[int]token.Position
+
 type Opcode byte
 
 type Definition struct {
 	Name 			string
 	OperandWidths 	[]int
+
+	// Pops and Pushes are the number of values the instruction takes off
+	// the stack and puts on it. If PopsLastOperand is set, the last
+	// operand counts further values popped, like the elements of OpArray.
+	Pops 			int
+	Pushes 			int
+	PopsLastOperand bool
 }
 
 const (
@@ -42,35 +54,71 @@ const (
 	OpReturn
 	OpGetLocal
 	OpSetLocal
+	OpGetBuiltin
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpCaptureLocal 	// push the cell of a local, moving the local into a new cell first
+	OpCaptureFree 	// push the cell of a free variable
+	OpIter 		// replace the value on top of the stack with an iterator over it
+	OpIterNext 	// push the next element and true, or pop the iterator and push false
+	OpSetFree
+	OpGreaterEqual
+	OpMod
+	OpPow
+	OpSetIndex 	// store the top of the stack at an index, leaving the value
+	OpDupPair 	// duplicate the two values on top of the stack
+	OpLessThan
+	OpLessEqual
+	OpJumpTruthy
+	OpCheckHashKey 	// fail unless the value on top of the stack can be a hash key
 )
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:    		{Name: "OpConstant", 		OperandWidths: []int{2}},
-	OpAdd: 		   		{Name: "OpAdd", 	 		OperandWidths: []int{}},
-	OpPop: 		   		{Name: "OpPop", 	 		OperandWidths: []int{}},
-	OpSub: 		   		{Name: "OpSub", 	 		OperandWidths: []int{}},
-	OpMul: 		   		{Name: "OpMul", 	 		OperandWidths: []int{}},
-	OpDiv: 		   		{Name: "OpDiv", 	 		OperandWidths: []int{}},
-	OpTrue: 	   		{Name: "OpTrue", 	 		OperandWidths: []int{}},
-	OpFalse: 	   		{Name: "OpFalse", 	 		OperandWidths: []int{}},
-	OpEqual: 	   		{Name: "OpEqual", 	 		OperandWidths: []int{}},
-	OpNotEqual:    		{Name: "OpNotEqual", 		OperandWidths: []int{}},
-	OpGreaterThan: 		{Name: "OpGreaterThan", 	OperandWidths: []int{}},
-	OpMinus:       		{Name: "OpMinus", 			OperandWidths: []int{}},
-	OpBang: 	   		{Name: "OpBang", 			OperandWidths: []int{}},
-	OpJumpNotTruthy: 	{Name: "OpJumpNotTruthy", 	OperandWidths: []int{2}},
-	OpJump: 			{Name: "OpJump", 			OperandWidths: []int{2}},
-	OpNull: 			{Name: "OpNull", 			OperandWidths: []int{}},
-	OpGetGlobal: 		{Name: "OpGetGlobal", 		OperandWidths: []int{2}},
-	OpSetGlobal: 		{Name: "OpSetGlobal", 		OperandWidths: []int{2}},
-	OpArray: 			{Name: "OpArray", 			OperandWidths: []int{2}},
-	OpHash: 			{Name: "OpHash", 			OperandWidths: []int{2}},
-	OpIndex: 			{Name: "OpIndex", 			OperandWidths: []int{}},
-	OpCall: 			{Name: "OpCall", 			OperandWidths: []int{1}},
-	OpReturnValue: 		{Name: "OpReturnValue", 	OperandWidths: []int{}},
-	OpReturn: 			{Name: "OpReturn", 			OperandWidths: []int{}},
-	OpGetLocal: 		{Name: "OpGetLocal", 		OperandWidths: []int{1}},
-	OpSetLocal: 		{Name: "OpSetLocal", 		OperandWidths: []int{1}},
+	OpConstant:			{Name: "OpConstant",		OperandWidths: []int{2},	Pops: 0, Pushes: 1},
+	OpAdd:				{Name: "OpAdd",				OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpPop:				{Name: "OpPop",				OperandWidths: []int{},		Pops: 1, Pushes: 0},
+	OpSub:				{Name: "OpSub",				OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpMul:				{Name: "OpMul",				OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpDiv:				{Name: "OpDiv",				OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpTrue:				{Name: "OpTrue",			OperandWidths: []int{},		Pops: 0, Pushes: 1},
+	OpFalse:			{Name: "OpFalse",			OperandWidths: []int{},		Pops: 0, Pushes: 1},
+	OpEqual:			{Name: "OpEqual",			OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpNotEqual:			{Name: "OpNotEqual",		OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpGreaterThan:		{Name: "OpGreaterThan",		OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpMinus:			{Name: "OpMinus",			OperandWidths: []int{},		Pops: 1, Pushes: 1},
+	OpBang:				{Name: "OpBang",			OperandWidths: []int{},		Pops: 1, Pushes: 1},
+	OpJumpNotTruthy:	{Name: "OpJumpNotTruthy",	OperandWidths: []int{2},	Pops: 1, Pushes: 0},
+	OpJump:				{Name: "OpJump",			OperandWidths: []int{2},	Pops: 0, Pushes: 0},
+	OpNull:				{Name: "OpNull",			OperandWidths: []int{},		Pops: 0, Pushes: 1},
+	OpGetGlobal:		{Name: "OpGetGlobal",		OperandWidths: []int{2},	Pops: 0, Pushes: 1},
+	OpSetGlobal:		{Name: "OpSetGlobal",		OperandWidths: []int{2},	Pops: 1, Pushes: 0},
+	OpArray:			{Name: "OpArray",			OperandWidths: []int{2},	Pops: 0, Pushes: 1, PopsLastOperand: true},
+	OpHash:				{Name: "OpHash",			OperandWidths: []int{2},	Pops: 0, Pushes: 1, PopsLastOperand: true},
+	OpIndex:			{Name: "OpIndex",			OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpCall:				{Name: "OpCall",			OperandWidths: []int{1},	Pops: 1, Pushes: 1, PopsLastOperand: true},
+	OpReturnValue:		{Name: "OpReturnValue",		OperandWidths: []int{},		Pops: 1, Pushes: 0},
+	OpReturn:			{Name: "OpReturn",			OperandWidths: []int{},		Pops: 0, Pushes: 0},
+	OpGetLocal:			{Name: "OpGetLocal",		OperandWidths: []int{1},	Pops: 0, Pushes: 1},
+	OpSetLocal:			{Name: "OpSetLocal",		OperandWidths: []int{1},	Pops: 1, Pushes: 0},
+	OpGetBuiltin:		{Name: "OpGetBuiltin",		OperandWidths: []int{1},	Pops: 0, Pushes: 1},
+	OpClosure:			{Name: "OpClosure",			OperandWidths: []int{2, 1},	Pops: 0, Pushes: 1, PopsLastOperand: true},
+	OpGetFree:			{Name: "OpGetFree",			OperandWidths: []int{1},	Pops: 0, Pushes: 1},
+	OpCurrentClosure:	{Name: "OpCurrentClosure",	OperandWidths: []int{},		Pops: 0, Pushes: 1},
+	OpCaptureLocal:		{Name: "OpCaptureLocal",	OperandWidths: []int{1},	Pops: 0, Pushes: 1},
+	OpCaptureFree:		{Name: "OpCaptureFree",		OperandWidths: []int{1},	Pops: 0, Pushes: 1},
+	OpIter:				{Name: "OpIter",			OperandWidths: []int{},		Pops: 1, Pushes: 1},
+	OpIterNext:			{Name: "OpIterNext",		OperandWidths: []int{},		Pops: 0, Pushes: 2},
+	OpSetFree:			{Name: "OpSetFree",			OperandWidths: []int{1},	Pops: 1, Pushes: 0},
+	OpGreaterEqual:		{Name: "OpGreaterEqual",	OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpMod:				{Name: "OpMod",				OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpPow:				{Name: "OpPow",				OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpSetIndex:			{Name: "OpSetIndex",		OperandWidths: []int{},		Pops: 3, Pushes: 1},
+	OpDupPair:			{Name: "OpDupPair",			OperandWidths: []int{},		Pops: 2, Pushes: 4},
+	OpLessThan:			{Name: "OpLessThan",		OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpLessEqual:		{Name: "OpLessEqual",		OperandWidths: []int{},		Pops: 2, Pushes: 1},
+	OpJumpTruthy:		{Name: "OpJumpTruthy",		OperandWidths: []int{2},	Pops: 1, Pushes: 0},
+	OpCheckHashKey:		{Name: "OpCheckHashKey",	OperandWidths: []int{},		Pops: 0, Pushes: 0},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -82,6 +130,16 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// LookupName returns the opcode called name, like "OpConstant".
+func LookupName(name string) (Opcode, *Definition, bool) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, def, true
+		}
+	}
+	return 0, nil, false
+}
+
 // MARK: -- make bytecode
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
@@ -89,13 +147,7 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
-	instructionLen := 1
-
-	for _, w := range def.OperandWidths {
-		instructionLen += w
-	}
-
-	instruction := make([]byte, instructionLen)
+	instruction := make([]byte, def.InstructionWidth())
 	instruction[0] = byte(op)
 
 	offset := 1
@@ -120,10 +172,16 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
 
+		if i + def.InstructionWidth() > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: truncated %s\n", i, def.Name)
+			break
+		}
+
 		operands, read := ReadOperands(def, ins[i + 1:])
 
 		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
@@ -146,11 +204,33 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
 
+// InstructionWidth is the number of bytes an instruction defined by def
+// takes up, opcode included.
+func (self *Definition) InstructionWidth() int {
+	width := 1
+	for _, w := range self.OperandWidths {
+		width += w
+	}
+	return width
+}
+
+// StackEffect returns how many values an instruction with the given
+// operands pops off the stack and pushes on it.
+func (self *Definition) StackEffect(operands []int) (int, int) {
+	pops := self.Pops
+	if self.PopsLastOperand {
+		pops += operands[len(operands) - 1]
+	}
+	return pops, self.Pushes
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
@@ -175,4 +255,24 @@ func ReadUint16(ins Instructions) uint16 {
 
 func ReadUint8(ins Instructions) uint8 {
 	return uint8(ins[0])
-}
\ No newline at end of file
+}
+
+// InstructionStart returns the offset of the instruction that contains
+// the byte at offset.
+func (ins Instructions) InstructionStart(offset int) int {
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			return offset
+		}
+
+		width := def.InstructionWidth()
+
+		if offset < i + width {
+			return i
+		}
+		i += width
+	}
+	return offset
+}
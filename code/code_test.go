@@ -11,6 +11,7 @@ func TestMake(t *testing.T) {
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, 	 []int{},  	   []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255},   []byte{byte(OpGetLocal), 255}},
+		{OpClosure,  []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 	}
 
 	for _, test := range tests {
@@ -34,12 +35,14 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{}
@@ -60,6 +63,7 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
 
 	for _, test := range tests {
@@ -75,10 +79,79 @@ func TestReadOperands(t *testing.T) {
 			t.Fatalf("n wrong. want=%d, got=%d", test.bytesRead, n)
 		}
 
+		if width := def.InstructionWidth(); width != len(instruction) {
+			t.Fatalf("InstructionWidth wrong. want=%d, got=%d", len(instruction), width)
+		}
+
 		for i, want := range test.operands {
 			if operandsRead[i] != want {
 				t.Errorf("operand want. want=%d, got=%d", want, operandsRead[i])
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+func TestMalformedInstructionsString(t *testing.T) {
+	tests := []struct{
+		ins 		Instructions
+		expected 	string
+	}{
+		{
+			Instructions{255, byte(OpAdd)},
+			"0000 ERROR: opcode 255 undefined\n0001 OpAdd\n",
+		},
+		{
+			append(Make(OpAdd), byte(OpConstant), 1),
+			"0000 OpAdd\n0001 ERROR: truncated OpConstant\n",
+		},
+		{
+			Instructions{byte(OpClosure), 0, 1},
+			"0000 ERROR: truncated OpClosure\n",
+		},
+	}
+
+	for _, test := range tests {
+		if test.ins.String() != test.expected {
+			t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", test.expected, test.ins.String())
+		}
+	}
+}
+
+func TestStackEffect(t *testing.T) {
+	tests := []struct {
+		op 		Opcode
+		operands []int
+		pops 	int
+		pushes 	int
+	}{
+		{OpConstant, []int{3}, 0, 1},
+		{OpAdd, []int{}, 2, 1},
+		{OpArray, []int{4}, 4, 1},
+		{OpCall, []int{2}, 3, 1},
+		{OpClosure, []int{7, 2}, 2, 1},
+		{OpSetIndex, []int{}, 3, 1},
+	}
+
+	for _, test := range tests {
+		def, err := Lookup(byte(test.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q", err)
+		}
+
+		pops, pushes := def.StackEffect(test.operands)
+		if pops != test.pops || pushes != test.pushes {
+			t.Errorf("wrong stack effect for %s. want=%d/%d, got=%d/%d",
+				def.Name, test.pops, test.pushes, pops, pushes)
+		}
+	}
+}
+
+func FuzzInstructionsString(f *testing.F) {
+	f.Add([]byte(Make(OpConstant, 65535)))
+	f.Add([]byte(Make(OpClosure, 1, 2)))
+	f.Add([]byte{byte(OpGetLocal)})
+
+	f.Fuzz(func(t *testing.T, ins []byte) {
+		_ = Instructions(ins).String()
+		Instructions(ins).InstructionStart(len(ins) / 2)
+	})
+}
@@ -2,13 +2,21 @@ package compiler
 
 import (
 	"fmt"
+	"sort"
 	"bear/ast"
 	"bear/code"
 	"bear/object"
-	"sort"
+	"bear/token"
 )
 
 
+var compoundAssignOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
 type EmittedInstruction struct {
 	Opcode 		code.Opcode
 	Position 	int
@@ -16,8 +24,18 @@ type EmittedInstruction struct {
 
 type CompilationScope struct {
 	instructions 		code.Instructions
+	sourceMap 			code.SourceMap
 	lastInstruction 	EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// loops enclosing the code being compiled, innermost last
+	loops 				[]*loop
+}
+
+type loop struct {
+	start 		int // position continue jumps back to
+	breaks 		[]int // positions of the jumps to patch with the loop's end
+	iterator 	bool // whether break has to pop an iterator off the stack
 }
 
 type Compiler struct {
@@ -27,27 +45,49 @@ type Compiler struct {
 
 	scopes 				[]CompilationScope
 	scopeIndex 			int
+
+	// source position of the node currently being compiled
+	position 			token.Position
+
+	// Optimize folds constant expressions, drops if branches that can
+	// never run, shares equal constants and runs the peephole optimizer
+	// over the instructions of every function.
+	Optimize 			bool
+
+	// constants by value, for sharing them when optimizing
+	constantIndexes 	map[constantKey]int
 }
 
 func New() *Compiler {
 	mainScope := CompilationScope{
 		instructions: 			code.Instructions{},
+		sourceMap: 				code.SourceMap{},
 		lastInstruction: 		EmittedInstruction{},
 		previousInstruction: 	EmittedInstruction{},
 	}
 
+	symbolTable := NewSymbolTable()
+
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
 	return &Compiler{
 		constants:		[]object.Object{},
-		symbolTable: 	NewSymbolTable(),
+		symbolTable: 	symbolTable,
 		scopes: 		[]CompilationScope{mainScope},
 		scopeIndex: 	0,
 	}
 }
 
 func (self *Compiler) Compile(node ast.Node) error {
+	defer self.trackPosition(node)()
+
 	switch node := node.(type) {
 
 	case *ast.Program:
+		self.declareFunctions(node.Statements)
+
 		for _, s := range node.Statements {
 			err := self.Compile(s)
 			if err != nil {
@@ -63,18 +103,15 @@ func (self *Compiler) Compile(node ast.Node) error {
 		self.emit(code.OpPop)
 
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := self.Compile(node.Right)
-			if err != nil {
-				return err
+		if self.Optimize {
+			if folded := fold(node); folded != nil {
+				self.emitFolded(folded)
+				return nil
 			}
+		}
 
-			err = self.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			self.emit(code.OpGreaterThan)
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return self.compileLogicalExpression(node)
 		}
 
 		err := self.Compile(node.Left)
@@ -96,20 +133,34 @@ func (self *Compiler) Compile(node ast.Node) error {
 			self.emit(code.OpMul)
 		case "/":
 			self.emit(code.OpDiv)
+		case "%":
+			self.emit(code.OpMod)
+		case "**":
+			self.emit(code.OpPow)
 		case ">":
 			self.emit(code.OpGreaterThan)
+		case ">=":
+			self.emit(code.OpGreaterEqual)
+		case "<":
+			self.emit(code.OpLessThan)
+		case "<=":
+			self.emit(code.OpLessEqual)
 		case "==":
 			self.emit(code.OpEqual)
 		case "!=":
 			self.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Token.Pos, node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		self.emit(code.OpConstant, self.addConstant(integer))
 
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		self.emit(code.OpConstant, self.addConstant(float))
+
 	case *ast.Boolean:
 		if node.Value {
 			self.emit(code.OpTrue)
@@ -118,6 +169,13 @@ func (self *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.PrefixExpression:
+		if self.Optimize {
+			if folded := fold(node); folded != nil {
+				self.emitFolded(folded)
+				return nil
+			}
+		}
+
 		err := self.Compile(node.Right)
 		if err != nil {
 			return err
@@ -129,10 +187,25 @@ func (self *Compiler) Compile(node ast.Node) error {
 		case "-":
 			self.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Token.Pos, node.Operator)
 		}
 
 	case *ast.IfExpression:
+		if self.Optimize {
+			if condition := fold(node.Condition); condition != nil {
+				// only the branch that runs is kept
+				live, dead := node.Consequence, node.Alternative
+				if !truthy(condition) {
+					live, dead = dead, live
+				}
+
+				if err := self.compileDeadBranch(dead); err != nil {
+					return err
+				}
+				return self.compileBranch(live)
+			}
+		}
+
 		err := self.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -140,37 +213,27 @@ func (self *Compiler) Compile(node ast.Node) error {
 
 		jumpNotTruthyPos := self.emit(code.OpJumpNotTruthy, 9999)
 
-		err = self.Compile(node.Consequence)
+		err = self.compileBranch(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if self.lastInstructionIs(code.OpPop) {
-			self.removeLastPop()
-		}
-
 		jumpPos := self.emit(code.OpJump, 9999)
 
 		afterConsequencePos := len(self.currentInstructions())
 		self.changeOperand(jumpNotTruthyPos, afterConsequencePos)
 
-		if node.Alternative == nil {
-			self.emit(code.OpNull)
-		} else {
-			err := self.Compile(node.Alternative)
-			if err != nil {
-				return err
-			}
-			
-			if self.lastInstructionIs(code.OpPop) {
-				self.removeLastPop()
-			}
+		err = self.compileBranch(node.Alternative)
+		if err != nil {
+			return err
 		}
 
 		afterAlternativePos := len(self.currentInstructions())
 		self.changeOperand(jumpPos, afterAlternativePos)
 
 	case *ast.BlockStatement:
+		self.declareFunctions(node.Statements)
+
 		for _, s := range node.Statements {
 			err := self.Compile(s)
 			if err != nil {
@@ -178,6 +241,70 @@ func (self *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
+	case *ast.WhileStatement:
+		start := len(self.currentInstructions())
+
+		err := self.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := self.emit(code.OpJumpNotTruthy, 9999)
+
+		err = self.compileLoopBody(node.Body, &loop{start: start})
+		if err != nil {
+			return err
+		}
+
+		self.changeOperand(jumpNotTruthyPos, len(self.currentInstructions()))
+
+	case *ast.ForStatement:
+		err := self.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+
+		restore := self.trackPosition(node.Iterable)
+		self.emit(code.OpIter)
+		restore()
+
+		start := self.emit(code.OpIterNext)
+		jumpNotTruthyPos := self.emit(code.OpJumpNotTruthy, 9999)
+
+		symbol := self.symbolTable.Define(node.Variable.Value)
+		if symbol.Scope == GlobalScope {
+			self.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			self.emit(code.OpSetLocal, symbol.Index)
+		}
+
+		err = self.compileLoopBody(node.Body, &loop{start: start, iterator: true})
+		if err != nil {
+			return err
+		}
+
+		self.changeOperand(jumpNotTruthyPos, len(self.currentInstructions()))
+
+	case *ast.BreakStatement:
+		current := self.currentLoop()
+		if current == nil {
+			return fmt.Errorf("%s: break outside loop", node.Token.Pos)
+		}
+
+		if current.iterator {
+			self.emit(code.OpPop)
+		}
+
+		current.breaks = append(current.breaks, self.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		current := self.currentLoop()
+		if current == nil {
+			return fmt.Errorf("%s: continue outside loop", node.Token.Pos)
+		}
+
+		self.emit(code.OpJump, current.start)
+
 	case *ast.LetStatement:
 		err := self.Compile(node.Value)
 		if err != nil {
@@ -190,21 +317,44 @@ func (self *Compiler) Compile(node ast.Node) error {
 		} else {
 			self.emit(code.OpSetLocal, symbol.Index)
 		}
-		
+
+	case *ast.AssignExpression:
+		if target, ok := node.Target.(*ast.IndexExpression); ok {
+			return self.compileIndexAssignment(node, target)
+		}
+
+		symbol, err := self.resolveAssignable(node.Target.(*ast.Identifier))
+		if err != nil {
+			return err
+		}
+
+		if node.Operator != "=" {
+			self.loadSymbol(symbol)
+		}
+
+		err = self.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if node.Operator != "=" {
+			op, ok := compoundAssignOperators[node.Operator]
+			if !ok {
+				return fmt.Errorf("%s: unknown operator %s", node.Token.Pos, node.Operator)
+			}
+			self.emit(op)
+		}
+
+		self.storeSymbol(symbol)
+		self.loadSymbol(symbol)
 
 	case *ast.Identifier:
 		symbol, ok := self.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
-		}
-
-		if symbol.Scope == GlobalScope {
-			self.emit(code.OpGetGlobal, symbol.Index)
-		} else {
-			self.emit(code.OpGetLocal, symbol.Index)
+			return fmt.Errorf("%s: identifier not found: %s", node.Token.Pos, node.Value)
 		}
 
-		
+		self.loadSymbol(symbol)
 
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
@@ -221,19 +371,15 @@ func (self *Compiler) Compile(node ast.Node) error {
 		self.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		for _, k := range node.Keys() {
 			err := self.Compile(k)
 			if err != nil {
 				return err
 			}
+			// a key that cannot be hashed fails before its value runs
+			if !isHashableLiteral(k) {
+				self.emit(code.OpCheckHashKey)
+			}
 			err = self.Compile(node.Pairs[k])
 			if err != nil {
 				return err
@@ -259,6 +405,14 @@ func (self *Compiler) Compile(node ast.Node) error {
 	case *ast.FunctionLiteral:
 		self.enterScope()
 
+		if node.Name != "" {
+			self.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			self.symbolTable.Define(p.Value)
+		}
+
 		err := self.Compile(node.Body)
 		if err != nil {
 			return err
@@ -272,11 +426,28 @@ func (self *Compiler) Compile(node ast.Node) error {
 			self.emit(code.OpReturn)
 		}
 
+		freeSymbols := self.symbolTable.FreeSymbols
 		numLocals := self.symbolTable.numDefinitions
+		sourceMap := self.scopes[self.scopeIndex].sourceMap
 		instructions := self.leaveScope()
 
-		compiledFn := &object.CompiledFunction{Instructions: instructions, NumLocals: numLocals,}
-		self.emit(code.OpConstant, self.addConstant(compiledFn))
+		if self.Optimize {
+			instructions, sourceMap = peephole(instructions, sourceMap)
+		}
+
+		for _, s := range freeSymbols {
+			self.captureSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions: 	instructions,
+			NumLocals: 		numLocals,
+			NumParameters: 	len(node.Parameters),
+			SourceMap: 		sourceMap,
+			Name: 			node.Name,
+		}
+		fnIndex := self.addConstant(compiledFn)
+		self.emit(code.OpClosure, fnIndex, len(freeSymbols))
 
 	case *ast.ReturnStatement:
 		err := self.Compile(node.ReturnValue)
@@ -292,33 +463,79 @@ func (self *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		self.emit(code.OpCall)
+		for _, a := range node.Arguments {
+			err := self.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		self.emit(code.OpCall, len(node.Arguments))
 	}
 
 	return nil
 }
 
 func (self *Compiler) Bytecode() *Bytecode {
+	instructions := self.currentInstructions()
+	sourceMap := self.scopes[self.scopeIndex].sourceMap
+
+	if self.Optimize {
+		instructions, sourceMap = peephole(instructions, sourceMap)
+	}
+
 	return &Bytecode{
-		Instructions: self.currentInstructions(),
+		Instructions: instructions,
 		Constants: 	  self.constants,
+		SourceMap: 	  sourceMap,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants 	 []object.Object
+	SourceMap 	 code.SourceMap
 }
 
 func (self *Compiler) addConstant(obj object.Object) int {
+	if self.Optimize {
+		if key, ok := keyOf(obj); ok {
+			if self.constantIndexes == nil {
+				self.indexConstants()
+			}
+			if index, ok := self.constantIndexes[key]; ok {
+				return index
+			}
+			self.constantIndexes[key] = len(self.constants)
+		}
+	}
+
 	self.constants = append(self.constants, obj)
 	return len(self.constants) - 1
 }
 
+// indexConstants records the constants already in the pool, which
+// NewWithState may have been given.
+func (self *Compiler) indexConstants() {
+	self.constantIndexes = map[constantKey]int{}
+	for i, constant := range self.constants {
+		if key, ok := keyOf(constant); ok {
+			if _, seen := self.constantIndexes[key]; !seen {
+				self.constantIndexes[key] = i
+			}
+		}
+	}
+}
+
 func (self *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := self.addInstruction(ins)
 	self.setLastInstruction(op, pos)
+
+	if self.position.IsValid() {
+		self.scopes[self.scopeIndex].sourceMap[pos] = self.position
+	}
+
 	return pos
 }
 
@@ -356,6 +573,8 @@ func (self *Compiler) removeLastPop() {
 
 	self.scopes[self.scopeIndex].instructions = new
 	self.scopes[self.scopeIndex].lastInstruction = previous
+
+	delete(self.scopes[self.scopeIndex].sourceMap, last.Position)
 }
 
 func (self *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -386,6 +605,7 @@ func (self *Compiler) currentInstructions() code.Instructions {
 func (self *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions: code.Instructions{},
+		sourceMap: code.SourceMap{},
 		lastInstruction: EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
@@ -406,6 +626,313 @@ func (self *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// trackPosition makes node the source of the instructions emitted until
+// the returned function restores the previous position. Operators are
+// attributed to their operator token rather than the start of the node.
+func (self *Compiler) trackPosition(node ast.Node) func() {
+	previous := self.position
+	restore := func() { self.position = previous }
+
+	if node == nil {
+		return restore
+	}
+
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		self.position = node.Token.Pos
+	case *ast.CallExpression:
+		self.position = node.Token.Pos
+	case *ast.IndexExpression:
+		self.position = node.Token.Pos
+	case *ast.AssignExpression:
+		self.position = node.Token.Pos
+	default:
+		if pos := node.Pos(); pos.IsValid() {
+			self.position = pos
+		}
+	}
+
+	return restore
+}
+
+func (self *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		self.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		self.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		self.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		self.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		self.emit(code.OpCurrentClosure)
+	}
+}
+
+// compileBranch compiles a branch of an if expression so that it leaves
+// its value on the stack, null if it has none or is missing.
+func (self *Compiler) compileBranch(block *ast.BlockStatement) error {
+	if block == nil {
+		self.emit(code.OpNull)
+		return nil
+	}
+
+	start := len(self.currentInstructions())
+
+	err := self.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	// an empty block leaves the last instruction of what came before
+	if len(self.currentInstructions()) > start && self.lastInstructionIs(code.OpPop) {
+		self.removeLastPop()
+	} else {
+		self.emit(code.OpNull)
+	}
+	return nil
+}
+
+// isHashableLiteral reports whether node is a literal that can always
+// be a hash key.
+func isHashableLiteral(node ast.Expression) bool {
+	switch node.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	}
+	return false
+}
+
+// compileDeadBranch compiles a branch that can never run on a copy of
+// the compiler and throws the result away, so that the branch fails to
+// compile as it would without optimizing and the names its let
+// statements define stay declared, though never set.
+func (self *Compiler) compileDeadBranch(block *ast.BlockStatement) error {
+	if block == nil {
+		return nil
+	}
+
+	scope := self.scopes[self.scopeIndex]
+	scope.instructions = append(code.Instructions{}, scope.instructions...)
+	scope.sourceMap = code.SourceMap{}
+	loops := []*loop{}
+	for _, l := range scope.loops {
+		copied := *l
+		copied.breaks = append([]int{}, l.breaks...)
+		loops = append(loops, &copied)
+	}
+	scope.loops = loops
+
+	dead := &Compiler{
+		constants: 		append([]object.Object{}, self.constants...),
+		symbolTable: 	self.symbolTable.Copy(),
+		scopes: 		append(append([]CompilationScope{}, self.scopes[:self.scopeIndex]...), scope),
+		scopeIndex: 	self.scopeIndex,
+		position: 		self.position,
+		Optimize: 		self.Optimize,
+	}
+
+	if err := dead.compileBranch(block); err != nil {
+		return err
+	}
+
+	defined := []Symbol{}
+	for name, symbol := range dead.symbolTable.store {
+		if symbol.Scope != GlobalScope && symbol.Scope != LocalScope {
+			continue
+		}
+		if existing, ok := self.symbolTable.store[name]; !ok || existing != symbol {
+			defined = append(defined, symbol)
+		}
+	}
+	sort.Slice(defined, func(i, j int) bool { return defined[i].Index < defined[j].Index })
+
+	for _, symbol := range defined {
+		self.symbolTable.Define(symbol.Name)
+	}
+	return nil
+}
+
+// compileLogicalExpression jumps over the right operand of && and || when
+// the left one decides the result. The result is always a boolean, the
+// right operand is converted with a double negation.
+func (self *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := self.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := self.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "||" {
+		self.emit(code.OpTrue)
+		jumpPos := self.emit(code.OpJump, 9999)
+
+		self.changeOperand(jumpNotTruthyPos, len(self.currentInstructions()))
+
+		err = self.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		self.emit(code.OpBang)
+		self.emit(code.OpBang)
+
+		self.changeOperand(jumpPos, len(self.currentInstructions()))
+		return nil
+	}
+
+	err = self.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	self.emit(code.OpBang)
+	self.emit(code.OpBang)
+
+	jumpPos := self.emit(code.OpJump, 9999)
+
+	self.changeOperand(jumpNotTruthyPos, len(self.currentInstructions()))
+	self.emit(code.OpFalse)
+
+	self.changeOperand(jumpPos, len(self.currentInstructions()))
+	return nil
+}
+
+// compileIndexAssignment evaluates the collection and the index once, a
+// compound assignment duplicates them to read the current element.
+func (self *Compiler) compileIndexAssignment(node *ast.AssignExpression, target *ast.IndexExpression) error {
+	err := self.Compile(target.Left)
+	if err != nil {
+		return err
+	}
+
+	err = self.Compile(target.Index)
+	if err != nil {
+		return err
+	}
+
+	if node.Operator != "=" {
+		self.emit(code.OpDupPair)
+		self.emit(code.OpIndex)
+	}
+
+	err = self.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	if node.Operator != "=" {
+		op, ok := compoundAssignOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Token.Pos, node.Operator)
+		}
+		self.emit(op)
+	}
+
+	self.emit(code.OpSetIndex)
+	return nil
+}
+
+func (self *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		self.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		self.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		self.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// resolveAssignable resolves the variable an assignment stores into.
+// Inside a function its own name refers to the closure being run, so an
+// assignment to it goes to the global binding it was defined with.
+func (self *Compiler) resolveAssignable(name *ast.Identifier) (Symbol, error) {
+	symbol, ok := self.symbolTable.Resolve(name.Value)
+	if !ok {
+		return symbol, fmt.Errorf("%s: assignment to undeclared variable %s", name.Token.Pos, name.Value)
+	}
+
+	if symbol.Scope == FunctionScope {
+		outer, ok := self.symbolTable.Outer.Resolve(name.Value)
+		if !ok || outer.Scope != GlobalScope {
+			return symbol, fmt.Errorf("%s: cannot assign to %s inside its own body", name.Token.Pos, name.Value)
+		}
+		symbol = outer
+	}
+
+	if symbol.Scope == BuiltinScope {
+		return symbol, fmt.Errorf("%s: cannot assign to builtin %s", name.Token.Pos, name.Value)
+	}
+
+	return symbol, nil
+}
+
+// declareFunctions defines every function bound by a let statement in
+// stmts up front, so functions can refer to each other regardless of
+// the order they are written in. Until its let statement runs, such a
+// function is an uninitialized variable.
+func (self *Compiler) declareFunctions(stmts []ast.Statement) {
+	for _, s := range stmts {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			self.symbolTable.Define(let.Name.Value)
+		}
+	}
+}
+
+// captureSymbol pushes the free variable s for a closure being created.
+// Locals and free variables are captured as the cell holding them, so
+// the closure sees later assignments and lets, and they see the
+// closure's.
+func (self *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		self.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		self.emit(code.OpCaptureFree, s.Index)
+	default:
+		self.loadSymbol(s)
+	}
+}
+
+// compileLoopBody compiles body followed by the jump back to the start
+// of l, and patches the breaks in body to jump past the loop.
+func (self *Compiler) compileLoopBody(body *ast.BlockStatement, l *loop) error {
+	scope := &self.scopes[self.scopeIndex]
+	scope.loops = append(scope.loops, l)
+
+	err := self.Compile(body)
+
+	scope = &self.scopes[self.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops) - 1]
+
+	if err != nil {
+		return err
+	}
+
+	self.emit(code.OpJump, l.start)
+
+	end := len(self.currentInstructions())
+	for _, pos := range l.breaks {
+		self.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
+func (self *Compiler) currentLoop() *loop {
+	loops := self.scopes[self.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops) - 1]
+}
+
 func (self *Compiler) replaceLastPopWithReturn() {
 	lastPos := self.scopes[self.scopeIndex].lastInstruction.Position
 	self.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
@@ -7,6 +7,7 @@ import (
 	"bear/lexer"
 	"bear/parser"
 	"bear/object"
+	"strings"
 	"testing"
 )
 
@@ -14,6 +15,7 @@ type compilerTestCase struct {
 	input 				 string
 	expectedConstants 	 []interface{}
 	expectedInstructions []code.Instructions
+	optimize 			 bool
 }
 
 func TestIntegerArithmetic(t *testing.T) {
@@ -74,6 +76,24 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: "5 % 2", expectedConstants: []interface{}{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "2 ** 3", expectedConstants: []interface{}{2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -86,6 +106,7 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		program := parse(test.input)
 
 		compiler := New()
+		compiler.Optimize = test.optimize
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -158,6 +179,11 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s", i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -222,11 +248,31 @@ func TestBooleanExpression(t *testing.T) {
 		},
 		{
 			input: "1 < 2", 
-			expectedConstants: []interface{}{2, 1}, 
+			expectedConstants: []interface{}{1, 2}, 
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessEqual),
 				code.Make(code.OpPop),
 			},
 		},
@@ -310,6 +356,30 @@ func TestConditionals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: `
+			if (true) { }; 3333;
+			`,
+			expectedConstants: []interface{}{3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpNull),
+				// 0005
+				code.Make(code.OpJump, 9),
+				// 0008
+				code.Make(code.OpNull),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpConstant, 0),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -485,6 +555,20 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// a key that is not a literal is checked before the value
+			input: "{1 + 2: 3}",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpCheckHashKey),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -541,7 +625,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -558,7 +642,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -639,7 +723,7 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -660,7 +744,7 @@ func TestFunctionCall(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), // The compiled function 
+				code.Make(code.OpClosure, 1, 0), // The compiled function 
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
@@ -678,13 +762,63 @@ func TestFunctionCall(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), // The compiled function
+				code.Make(code.OpClosure, 1, 0), // The compiled function
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: `
+			let oneArg = fn(a) { a };
+			oneArg(24);
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let manyArg = fn(a, b, c) { a; b; c };
+			manyArg(24, 25, 26);
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+				25,
+				26,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpCall, 3),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -707,7 +841,7 @@ func TestLetStatementScope(t *testing.T) {
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -728,7 +862,7 @@ func TestLetStatementScope(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -755,7 +889,175 @@ func TestLetStatementScope(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			len([]);
+			push([], 1);
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { len([]) }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					a + b
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					fn(c) {
+						a + b + c
+					}
+				}
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let global = 55;
+
+			fn() {
+				let a = 66;
+
+				fn() {
+					let b = 77;
+
+					fn() {
+						let c = 88;
+
+						global + a + b + c;
+					}
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				55,
+				66,
+				77,
+				88,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 4, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 5, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 6, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -764,3 +1066,986 @@ func TestLetStatementScope(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			fn() {
+				let a = fn() { b() };
+				let b = fn() { a() };
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 1),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct{
+		input 			string
+		expectedError 	string
+	}{
+		{"1 + foo", "1:5: identifier not found: foo"},
+		{"let f = fn() {\n  bar\n};", "2:3: identifier not found: bar"},
+		{"x = 1", "1:1: assignment to undeclared variable x"},
+		{"len += 1", "1:1: cannot assign to builtin len"},
+		{"fn() { let f = fn() { f = 1 }; }", "1:23: cannot assign to f inside its own body"},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Errorf("expected compiler error for %q, got none", test.input)
+			continue
+		}
+
+		if err.Error() != test.expectedError {
+			t.Errorf("wrong compiler error. want=%q, got=%q", test.expectedError, err)
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	program := parse("1 +\n  2;\nfn() { 3 * 4 }")
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+
+	expected := map[int]string{
+		0: "1:1", // OpConstant 1
+		3: "2:3", // OpConstant 2
+		6: "1:3", // OpAdd
+		7: "1:1", // OpPop
+		8: "3:1", // OpClosure
+	}
+
+	for offset, want := range expected {
+		got := bytecode.SourceMap[offset]
+		if got.String() != want {
+			t.Errorf("wrong position for instruction at %d. want=%s, got=%s", offset, want, got)
+		}
+	}
+
+	fn, ok := bytecode.Constants[4].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 4 is not a function. got=%T", bytecode.Constants[4])
+	}
+
+	if got := fn.SourceMap[6].String(); got != "3:10" {
+		t.Errorf("wrong position for OpMul. want=3:10, got=%s", got)
+	}
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+
+	return nil
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "1.5 + 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "-0.5",
+			expectedConstants: []interface{}{0.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `while (true) { 1; }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input: `while (true) { break; continue; }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpJump, 13),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input: `for (x in [1, 2]) { x; }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpArray, 2),
+				// 0009
+				code.Make(code.OpIter),
+				// 0010
+				code.Make(code.OpIterNext),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 24),
+				// 0014
+				code.Make(code.OpSetGlobal, 0),
+				// 0017
+				code.Make(code.OpGetGlobal, 0),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpJump, 10),
+			},
+		},
+		{
+			input: `fn() { for (c in "ab") { break; } }`,
+			expectedConstants: []interface{}{
+				"ab",
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpIter),
+					// 0004
+					code.Make(code.OpIterNext),
+					// 0005
+					code.Make(code.OpJumpNotTruthy, 17),
+					// 0008
+					code.Make(code.OpSetLocal, 0),
+					// 0010
+					code.Make(code.OpPop),
+					// 0011
+					code.Make(code.OpJump, 17),
+					// 0014
+					code.Make(code.OpJump, 4),
+					// 0017
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLetRedefinitionReusesSlot(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let i = 0;
+			let i = i + 1;
+			`,
+			expectedConstants: []interface{}{0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let x = 1;
+			x = 2;
+			`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn() { let x = 1; x -= 2; }
+			`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSub),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn(a) { fn() { a *= 2 } }
+			`,
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMul),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let f = fn() { f = 1 };
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpBang),
+				// 0006
+				code.Make(code.OpBang),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpFalse),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 11),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpBang),
+				// 0010
+				code.Make(code.OpBang),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "[1, 2][0] = 3",
+			expectedConstants: []interface{}{1, 2, 0, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let h = {}; h["a"] += 1`,
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDupPair),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// FuzzCompile checks that the compiler never panics on a program the
+// parser accepts and that the bytecode it emits can be decoded.
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: "-(2 ** 10) % 1000",
+			expectedConstants: []interface{}{-24},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: `"foo" + "bar"`,
+			expectedConstants: []interface{}{"foobar"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: `1 < 2 == ("a" >= "b")`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: `!0 || (false && x)`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// only the constant part of the expression is folded
+			input: "let x = 1; x + (2 * 3)",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Expressions that fail or depend on --check-overflow are left to the VM.
+func TestConstantFoldingLeavesErrors(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "1 / 0",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: "9223372036854775807 + 1",
+			expectedConstants: []interface{}{9223372036854775807, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: `true + 1`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: `"a" - "b"`,
+			expectedConstants: []interface{}{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSub),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDeadBranchElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "if (true) { 10 } else { 20 }",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: "let x = if (1 > 2) { 10 };",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpSetGlobal, 0),
+			},
+			optimize: true,
+		},
+		{
+			// the empty branch leaves null, not the value before it,
+			// which the peephole optimizer then drops
+			input: `"x"; if ("" == "") { }`,
+			expectedConstants: []interface{}{"x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// nothing the dead branch compiles to is kept
+			input: "if (false) { 2; fn() { 3 } } else { 1 }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// the let in the dead branch still declares x
+			input: "if (false) { let x = 1; } puts(x);",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// the dead branch still has to compile
+	compiler := New()
+	compiler.Optimize = true
+	err := compiler.Compile(parse(`if (false) { nope(); } puts("ok");`))
+	if err == nil || err.Error() != "1:14: identifier not found: nope" {
+		t.Errorf("wrong compiler error. want=%q, got=%v", "1:14: identifier not found: nope", err)
+	}
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let a = 1; let b = "s"; [1, "s", 1.5, 1.5, fn() { 1 }]`,
+			expectedConstants: []interface{}{
+				1,
+				"s",
+				1.5,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpArray, 5),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: "1; 1",
+			expectedConstants: []interface{}{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Equal constants are shared with the ones a REPL session compiled
+// before.
+func TestConstantDeduplicationWithState(t *testing.T) {
+	constants := []object.Object{&object.Integer{Value: 7}, &object.String{Value: "s"}}
+
+	compiler := NewWithState(NewSymbolTable(), constants)
+	compiler.Optimize = true
+	if err := compiler.Compile(parse(`["s", 7, 8]`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+
+	err := testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpArray, 3),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants(t, []interface{}{7, "s", 8}, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// the last value of the program is kept for the REPL
+			input: "let x = 1; 2; x",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// reading a variable fails if it is not set yet
+			input: "f; let f = fn() { 1 }; f",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input: "fn(a) { while (!a) { a = true; } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpTruthy, 11),
+					code.Make(code.OpTrue),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpJump, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// both conditions jump straight out of the loop
+			input: "fn(a, b) { while (a && b) { a = false; } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 16),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpJumpNotTruthy, 16),
+					code.Make(code.OpFalse),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpJump, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// the jumps to the end of the outer if return instead
+			input: "fn(a, b) { if (a) { if (b) { 1 } else { 2 } } else { 3 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				3,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 18),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpJumpNotTruthy, 14),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// the value of the if is never used, but reading the
+			// condition stays
+			input: "fn(a) { if (a) { 1 } else { 2 }; a }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// the test after OpIterNext stays, the break jumps out of
+			// the loop over the code after it
+			input: "fn(a) { for (x in a) { if (x) { break; } } 1 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpIter),
+					code.Make(code.OpIterNext),
+					code.Make(code.OpJumpNotTruthy, 15),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpJumpNotTruthy, 3),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+			optimize: true,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// The source map follows the instructions the peephole optimizer moves.
+func TestPeepholeSourceMap(t *testing.T) {
+	compiler := New()
+	compiler.Optimize = true
+	if err := compiler.Compile(parse("let a = 1;\na;\nif (!a) { 2 }\na / 0")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	offset := len(bytecode.Instructions) - len(code.Make(code.OpDiv)) - len(code.Make(code.OpPop))
+
+	if code.Opcode(bytecode.Instructions[offset]) != code.OpDiv {
+		t.Fatalf("no OpDiv at %d in\n%s", offset, bytecode.Instructions)
+	}
+
+	pos := bytecode.SourceMap[offset]
+	if pos.Line != 4 || pos.Column != 3 {
+		t.Errorf("wrong position of OpDiv. want=4:3, got=%s", pos)
+	}
+}
+
+func FuzzCompile(f *testing.F) {
+	f.Add(`let f = fn(n) { if (n < 2) { return n; } f(n - 1) + f(n - 2) }; f(10)`)
+	f.Add(`let a = [1, 2]; a[0] += 1; let h = {"k": a}; h["k"][1] = 3;`)
+	f.Add(`let i = 0; while (i < 3) { i += 1; if (i == 1) { continue; } break; }`)
+	f.Add(`for (x in [1, 2]) { let g = fn() { x && !x || x ** 2 % 3 }; g(); }`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		par := parser.New(lexer.New(input))
+		program := par.ParseProgram()
+		if len(par.Errors()) != 0 {
+			return
+		}
+
+		symbolTable := NewSymbolTable()
+		for i, v := range object.Builtins {
+			symbolTable.DefineBuiltin(i, v.Name)
+		}
+
+		compiler := NewWithState(symbolTable, []object.Object{})
+		if compiler.Compile(program) != nil {
+			return
+		}
+
+		bytecode := compiler.Bytecode()
+		checkDecodable(t, input, bytecode.Instructions)
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				checkDecodable(t, input, fn.Instructions)
+			}
+		}
+	})
+}
+
+func checkDecodable(t *testing.T, input string, ins code.Instructions) {
+	t.Helper()
+
+	if strings.Contains(ins.String(), "ERROR") {
+		t.Fatalf("compiled %q to malformed instructions:\n%s", input, ins)
+	}
+}
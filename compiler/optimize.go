@@ -0,0 +1,226 @@
+package compiler
+
+import (
+	"bear/ast"
+	"bear/code"
+	"bear/object"
+	"math"
+)
+
+// The optimizations Compiler.Optimize turns on. Expressions made only of
+// integer, string and boolean literals are evaluated while compiling,
+// if expressions with such a condition only compile the branch that
+// runs, and equal constants share a slot in the constant pool.
+//
+// Folding must never change what a program does, so expressions whose
+// evaluation fails or depends on how the VM is run are left for the VM:
+// division by zero, integer overflow, which is only an error with
+// --check-overflow, and operators on mismatched types.
+
+// fold returns the value of a constant expression, or nil if node is not
+// one or evaluating it is left to the VM.
+func fold(node ast.Expression) object.Object {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}
+
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}
+
+	case *ast.PrefixExpression:
+		right := fold(node.Right)
+		if right == nil {
+			return nil
+		}
+
+		switch node.Operator {
+		case "!":
+			return &object.Boolean{Value: !truthy(right)}
+		case "-":
+			if right, ok := right.(*object.Integer); ok {
+				return &object.Integer{Value: -right.Value}
+			}
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return foldLogical(node)
+		}
+
+		left := fold(node.Left)
+		if left == nil {
+			return nil
+		}
+		right := fold(node.Right)
+		if right == nil {
+			return nil
+		}
+
+		switch left := left.(type) {
+		case *object.Integer:
+			if right, ok := right.(*object.Integer); ok {
+				return foldInteger(node.Operator, left.Value, right.Value)
+			}
+		case *object.String:
+			if right, ok := right.(*object.String); ok {
+				return foldString(node.Operator, left.Value, right.Value)
+			}
+		case *object.Boolean:
+			if right, ok := right.(*object.Boolean); ok {
+				return foldBoolean(node.Operator, left.Value, right.Value)
+			}
+		}
+	}
+
+	return nil
+}
+
+// foldLogical folds && and ||, whose right operand only has to be
+// constant if the left one does not decide the result.
+func foldLogical(node *ast.InfixExpression) object.Object {
+	left := fold(node.Left)
+	if left == nil {
+		return nil
+	}
+
+	if node.Operator == "&&" && !truthy(left) {
+		return &object.Boolean{Value: false}
+	}
+	if node.Operator == "||" && truthy(left) {
+		return &object.Boolean{Value: true}
+	}
+
+	right := fold(node.Right)
+	if right == nil {
+		return nil
+	}
+	return &object.Boolean{Value: truthy(right)}
+}
+
+func foldInteger(operator string, left, right int64) object.Object {
+	var result int64
+	var overflow bool
+
+	switch operator {
+	case "+":
+		result, overflow = object.AddInt(left, right)
+	case "-":
+		result, overflow = object.SubInt(left, right)
+	case "*":
+		result, overflow = object.MulInt(left, right)
+	case "/":
+		if right == 0 {
+			return nil
+		}
+		result, overflow = object.DivInt(left, right)
+	case "%":
+		if right == 0 {
+			return nil
+		}
+		result = left % right
+	case "**":
+		// a negative exponent gives a float
+		if right < 0 {
+			return nil
+		}
+		result, overflow = object.PowInt(left, right)
+	case "<":
+		return &object.Boolean{Value: left < right}
+	case "<=":
+		return &object.Boolean{Value: left <= right}
+	case ">":
+		return &object.Boolean{Value: left > right}
+	case ">=":
+		return &object.Boolean{Value: left >= right}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	default:
+		return nil
+	}
+
+	if overflow {
+		return nil
+	}
+	return &object.Integer{Value: result}
+}
+
+func foldString(operator string, left, right string) object.Object {
+	switch operator {
+	case "+":
+		return &object.String{Value: left + right}
+	case "<":
+		return &object.Boolean{Value: left < right}
+	case "<=":
+		return &object.Boolean{Value: left <= right}
+	case ">":
+		return &object.Boolean{Value: left > right}
+	case ">=":
+		return &object.Boolean{Value: left >= right}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	}
+	return nil
+}
+
+func foldBoolean(operator string, left, right bool) object.Object {
+	switch operator {
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	}
+	return nil
+}
+
+// truthy reports whether a folded value counts as true in a condition;
+// only false and null do not, and null is never folded.
+func truthy(obj object.Object) bool {
+	if boolean, ok := obj.(*object.Boolean); ok {
+		return boolean.Value
+	}
+	return true
+}
+
+// emitFolded emits the instruction that loads a folded value.
+func (self *Compiler) emitFolded(obj object.Object) {
+	if boolean, ok := obj.(*object.Boolean); ok {
+		if boolean.Value {
+			self.emit(code.OpTrue)
+		} else {
+			self.emit(code.OpFalse)
+		}
+		return
+	}
+
+	self.emit(code.OpConstant, self.addConstant(obj))
+}
+
+// constantKey identifies the value of a constant that instructions can
+// share with every other constant of the same value.
+type constantKey struct {
+	kind 	object.ObjectType
+	bits 	uint64
+	text 	string
+}
+
+// keyOf returns the key of a constant, or false for constants that are
+// never shared, like compiled functions.
+func keyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{kind: obj.Type(), bits: uint64(obj.Value)}, true
+	case *object.Float:
+		// by bits, so that 0.0 and -0.0 stay apart
+		return constantKey{kind: obj.Type(), bits: math.Float64bits(obj.Value)}, true
+	case *object.String:
+		return constantKey{kind: obj.Type(), text: obj.Value}, true
+	}
+	return constantKey{}, false
+}
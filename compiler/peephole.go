@@ -0,0 +1,461 @@
+package compiler
+
+import (
+	"bear/code"
+	"bear/token"
+)
+
+// peephole rewrites the instructions of the main program or of a function
+// into fewer instructions doing the same, by looking at a few neighbouring
+// instructions at a time:
+//
+//   - instructions no path reaches are removed
+//   - a jump to another jump goes straight to where that one goes, and
+//     a jump to a return returns
+//   - a jump to the next instruction is removed
+//   - OpBang followed by a conditional jump becomes the opposite jump,
+//     and so does a conditional jump over an OpJump
+//   - OpBang on a constant and a conditional jump on a constant are
+//     decided while compiling
+//   - a value pushed only to be popped again is never pushed
+//
+// The rules are applied until none matches any more, then the jumps are
+// pointed at the new offsets of their targets. If ins cannot be decoded
+// it is returned unchanged.
+func peephole(ins code.Instructions, sourceMap code.SourceMap) (code.Instructions, code.SourceMap) {
+	list, ok := decodePeephole(ins, sourceMap)
+	if !ok {
+		return ins, sourceMap
+	}
+
+	rules := []func([]*peepholeInstruction, []int) bool{
+		removeUnreachable,
+		threadJumps,
+		removeJumpsToNext,
+		invertNegatedJumps,
+		invertJumpsOverJumps,
+		foldConstantConditions,
+		removePushPop,
+	}
+
+	for changed := true ; changed ; {
+		changed = false
+		for _, rule := range rules {
+			if rule(list, jumpTargets(list)) {
+				list = compact(list)
+				changed = true
+			}
+		}
+	}
+
+	return encodePeephole(list)
+}
+
+// peepholeInstruction is a decoded instruction. The operand of a jump is
+// the index of the instruction it lands on, len(list) for the end.
+type peepholeInstruction struct {
+	op 			code.Opcode
+	operands 	[]int
+	pos 		token.Position
+	removed 	bool
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpJumpTruthy
+}
+
+func isConditionalJump(op code.Opcode) bool {
+	return op == code.OpJumpNotTruthy || op == code.OpJumpTruthy
+}
+
+// pushesConstant reports whether in pushes true, false or null, and
+// whether that value is truthy.
+func pushesConstant(in *peepholeInstruction) (bool, bool) {
+	switch in.op {
+	case code.OpTrue:
+		return true, true
+	case code.OpFalse, code.OpNull:
+		return false, true
+	}
+	return false, false
+}
+
+// pushesOnly reports whether in does nothing but push a value. Reading
+// a variable is not, since it fails if the variable is not yet set.
+func pushesOnly(in *peepholeInstruction) bool {
+	switch in.op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetBuiltin, code.OpCurrentClosure:
+		return true
+	}
+	return false
+}
+
+// readsJustSet reports whether list[i] reads the variable the
+// instruction before it sets, which cannot fail as it is set.
+func readsJustSet(list []*peepholeInstruction, targets []int, i int) bool {
+	if i == 0 || targets[i] > 0 || list[i - 1].removed {
+		return false
+	}
+
+	set := map[code.Opcode]code.Opcode{
+		code.OpGetGlobal: 	code.OpSetGlobal,
+		code.OpGetLocal: 	code.OpSetLocal,
+		code.OpGetFree: 	code.OpSetFree,
+	}
+	op, ok := set[list[i].op]
+	return ok && list[i - 1].op == op && list[i - 1].operands[0] == list[i].operands[0]
+}
+
+func decodePeephole(ins code.Instructions, sourceMap code.SourceMap) ([]*peepholeInstruction, bool) {
+	list := []*peepholeInstruction{}
+	indexes := map[int]int{}
+
+	offset := 0
+	for offset < len(ins) {
+		def, err := code.Lookup(ins[offset])
+		if err != nil || offset + def.InstructionWidth() > len(ins) {
+			return nil, false
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset + 1:])
+		indexes[offset] = len(list)
+		list = append(list, &peepholeInstruction{
+			op: 		code.Opcode(ins[offset]),
+			operands: 	operands,
+			pos: 		sourceMap[offset],
+		})
+
+		offset += 1 + read
+	}
+	indexes[len(ins)] = len(list)
+
+	for _, in := range list {
+		if !isJump(in.op) {
+			continue
+		}
+		index, ok := indexes[in.operands[0]]
+		if !ok {
+			return nil, false
+		}
+		in.operands[0] = index
+	}
+
+	return list, true
+}
+
+func encodePeephole(list []*peepholeInstruction) (code.Instructions, code.SourceMap) {
+	offsets := make([]int, len(list) + 1)
+	for i, in := range list {
+		def, _ := code.Lookup(byte(in.op))
+		offsets[i + 1] = offsets[i] + def.InstructionWidth()
+	}
+
+	ins := code.Instructions{}
+	sourceMap := code.SourceMap{}
+
+	for i, in := range list {
+		operands := in.operands
+		if isJump(in.op) {
+			operands = []int{offsets[in.operands[0]]}
+		}
+
+		ins = append(ins, code.Make(in.op, operands...)...)
+		if in.pos.IsValid() {
+			sourceMap[offsets[i]] = in.pos
+		}
+	}
+
+	return ins, sourceMap
+}
+
+// jumpTargets counts the jumps landing on each instruction.
+func jumpTargets(list []*peepholeInstruction) []int {
+	targets := make([]int, len(list) + 1)
+	for _, in := range list {
+		if isJump(in.op) {
+			targets[in.operands[0]]++
+		}
+	}
+	return targets
+}
+
+// compact drops the removed instructions. Jumps to a removed instruction
+// land on the next one that is kept.
+func compact(list []*peepholeInstruction) []*peepholeInstruction {
+	indexes := make([]int, len(list) + 1)
+	kept := []*peepholeInstruction{}
+
+	for i, in := range list {
+		indexes[i] = len(kept)
+		if !in.removed {
+			kept = append(kept, in)
+		}
+	}
+	indexes[len(list)] = len(kept)
+
+	for _, in := range kept {
+		if isJump(in.op) {
+			in.operands[0] = indexes[in.operands[0]]
+		}
+	}
+
+	return kept
+}
+
+func removeUnreachable(list []*peepholeInstruction, targets []int) bool {
+	reached := make([]bool, len(list) + 1)
+	work := []int{0}
+	reached[0] = true
+
+	for len(work) > 0 {
+		i := work[len(work) - 1]
+		work = work[:len(work) - 1]
+		if i == len(list) {
+			continue
+		}
+
+		next := []int{i + 1}
+		switch in := list[i]; in.op {
+		case code.OpJump:
+			next = []int{in.operands[0]}
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
+			next = append(next, in.operands[0])
+		case code.OpReturn, code.OpReturnValue:
+			next = nil
+		}
+
+		for _, n := range next {
+			if !reached[n] {
+				reached[n] = true
+				work = append(work, n)
+			}
+		}
+	}
+
+	changed := false
+	for i, in := range list {
+		if !reached[i] {
+			in.removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func threadJumps(list []*peepholeInstruction, targets []int) bool {
+	changed := false
+
+	for _, in := range list {
+		if !isJump(in.op) {
+			continue
+		}
+
+		target := in.operands[0]
+		seen := map[int]bool{}
+		for target < len(list) && !seen[target] {
+			seen[target] = true
+			next, ok := jumpThrough(list, target)
+			if !ok {
+				break
+			}
+			target = next
+		}
+
+		if target != in.operands[0] {
+			in.operands[0] = target
+			changed = true
+		}
+
+		// a jump to a return might as well return
+		if in.op == code.OpJump && target < len(list) &&
+			(list[target].op == code.OpReturn || list[target].op == code.OpReturnValue) {
+			in.op = list[target].op
+			in.operands = []int{}
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// jumpThrough returns where execution goes on from list[i] if all it does
+// there is jump: an OpJump, or true, false or null tested right away, like
+// the value of && and || in a condition.
+func jumpThrough(list []*peepholeInstruction, i int) (int, bool) {
+	if list[i].op == code.OpJump {
+		return list[i].operands[0], true
+	}
+
+	value, ok := pushesConstant(list[i])
+	if !ok || i + 1 == len(list) || !isConditionalJump(list[i + 1].op) {
+		return 0, false
+	}
+
+	if value == (list[i + 1].op == code.OpJumpTruthy) {
+		return list[i + 1].operands[0], true
+	}
+	return i + 2, true
+}
+
+// removeJumpsToNext removes jumps to the next instruction. A conditional
+// one still has to pop its condition, unless it belongs to OpIterNext,
+// which relies on it.
+func removeJumpsToNext(list []*peepholeInstruction, targets []int) bool {
+	changed := false
+
+	for i, in := range list {
+		if !isJump(in.op) || in.operands[0] != i + 1 {
+			continue
+		}
+
+		switch {
+		case in.op == code.OpJump:
+			in.removed = true
+			changed = true
+		case i == 0 || list[i - 1].op != code.OpIterNext:
+			in.op = code.OpPop
+			in.operands = []int{}
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func invertNegatedJumps(list []*peepholeInstruction, targets []int) bool {
+	changed := false
+
+	for i := 0 ; i + 1 < len(list) ; i++ {
+		bang, jump := list[i], list[i + 1]
+		if bang.op != code.OpBang || !isConditionalJump(jump.op) || targets[i + 1] > 0 {
+			continue
+		}
+
+		bang.removed = true
+		if jump.op == code.OpJumpNotTruthy {
+			jump.op = code.OpJumpTruthy
+		} else {
+			jump.op = code.OpJumpNotTruthy
+		}
+		changed = true
+		i++
+	}
+
+	return changed
+}
+
+// invertJumpsOverJumps turns a conditional jump over an OpJump into the
+// opposite jump to where the OpJump goes.
+func invertJumpsOverJumps(list []*peepholeInstruction, targets []int) bool {
+	changed := false
+
+	for i := 0 ; i + 1 < len(list) ; i++ {
+		test, jump := list[i], list[i + 1]
+		if !isConditionalJump(test.op) || test.operands[0] != i + 2 ||
+			jump.op != code.OpJump || targets[i + 1] > 0 {
+			continue
+		}
+		if i > 0 && list[i - 1].op == code.OpIterNext {
+			continue
+		}
+
+		jump.removed = true
+		test.operands[0] = jump.operands[0]
+		if test.op == code.OpJumpNotTruthy {
+			test.op = code.OpJumpTruthy
+		} else {
+			test.op = code.OpJumpNotTruthy
+		}
+		changed = true
+		i++
+	}
+
+	return changed
+}
+
+// foldConstantConditions decides OpBang and conditional jumps on true,
+// false and null, and a jump to a conditional jump after one of them.
+func foldConstantConditions(list []*peepholeInstruction, targets []int) bool {
+	changed := false
+
+	for i := 0 ; i + 1 < len(list) ; i++ {
+		value, ok := pushesConstant(list[i])
+		if !ok {
+			continue
+		}
+		next := list[i + 1]
+
+		switch {
+		case next.op == code.OpBang && targets[i + 1] == 0:
+			next.removed = true
+			if value {
+				list[i].op = code.OpFalse
+			} else {
+				list[i].op = code.OpTrue
+			}
+
+		case isConditionalJump(next.op) && targets[i + 1] == 0:
+			list[i].removed = true
+			if value == (next.op == code.OpJumpTruthy) {
+				next.op = code.OpJump
+			} else {
+				next.removed = true
+			}
+
+		case next.op == code.OpJump && targets[i + 1] == 0:
+			target := next.operands[0]
+			if target == len(list) || !isConditionalJump(list[target].op) ||
+				target > 0 && list[target - 1].op == code.OpIterNext {
+				continue
+			}
+
+			list[i].removed = true
+			if value == (list[target].op == code.OpJumpTruthy) {
+				next.operands[0] = list[target].operands[0]
+			} else {
+				next.operands[0] = target + 1
+			}
+
+		default:
+			continue
+		}
+
+		changed = true
+		i++
+	}
+
+	return changed
+}
+
+// removePushPop removes a value that is pushed and popped right away, or
+// pushed before a jump to an OpPop. The last OpPop of the main program is
+// kept: it leaves the value of the last expression for the REPL.
+func removePushPop(list []*peepholeInstruction, targets []int) bool {
+	changed := false
+
+	for i := 0 ; i + 1 < len(list) ; i++ {
+		if !(pushesOnly(list[i]) || readsJustSet(list, targets, i)) || targets[i + 1] > 0 {
+			continue
+		}
+		next := list[i + 1]
+
+		switch {
+		case next.op == code.OpPop && i + 2 < len(list):
+			list[i].removed = true
+			next.removed = true
+
+		case next.op == code.OpJump && next.operands[0] < len(list) - 1 &&
+			list[next.operands[0]].op == code.OpPop:
+			list[i].removed = true
+			next.operands[0]++
+
+		default:
+			continue
+		}
+
+		changed = true
+		i++
+	}
+
+	return changed
+}
@@ -0,0 +1,371 @@
+package compiler
+
+import (
+	"bear/code"
+	"bear/object"
+	"bear/token"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+// A serialized Bytecode, stored in .bearc files, is laid out as
+//
+//	magic     "BEARC\x00"
+//	version   uint16, FormatVersion
+//	length    uint32, length of the payload
+//	checksum  uint32, CRC-32 (IEEE) of the payload
+//	payload
+//
+// The payload holds the file names positions refer to, the main
+// instructions with their source map and the constant pool. Integers
+// in the payload are varints, strings and byte slices are prefixed with
+// their length.
+const Magic = "BEARC\x00"
+
+// FormatVersion is the version of the serialized format. It has to be
+// bumped whenever the format or the meaning of the instructions changes,
+// for example when an opcode is added.
+const FormatVersion = 4
+
+const headerSize = len(Magic) + 2 + 4 + 4
+
+// constant tags
+const (
+	tagInteger 	byte = 'i'
+	tagFloat 	byte = 'f'
+	tagString 	byte = 's'
+	tagFunction byte = 'c'
+)
+
+// IsSerialized reports whether data starts like serialized bytecode.
+func IsSerialized(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// MarshalBinary serializes the bytecode to the .bearc format.
+func (self *Bytecode) MarshalBinary() ([]byte, error) {
+	enc := &encoder{filenames: map[string]int{}}
+
+	// positions refer to file names by index, so collect them first
+	enc.collectFilenames(self.SourceMap)
+	for _, constant := range self.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			enc.collectFilenames(fn.SourceMap)
+		}
+	}
+
+	var payload bytes.Buffer
+	enc.out = &payload
+
+	enc.uvarint(len(enc.names))
+	for _, name := range enc.names {
+		enc.string(name)
+	}
+
+	enc.bytes(self.Instructions)
+	enc.sourceMap(self.SourceMap)
+
+	enc.uvarint(len(self.Constants))
+	for i, constant := range self.Constants {
+		if err := enc.constant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	out := make([]byte, headerSize, headerSize + payload.Len())
+	copy(out, Magic)
+	binary.BigEndian.PutUint16(out[len(Magic):], FormatVersion)
+	binary.BigEndian.PutUint32(out[len(Magic) + 2:], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(out[len(Magic) + 6:], crc32.ChecksumIEEE(payload.Bytes()))
+
+	return append(out, payload.Bytes()...), nil
+}
+
+// UnmarshalBinary loads bytecode serialized by MarshalBinary, checking
+// its magic, version, length and checksum.
+func (self *Bytecode) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize || !IsSerialized(data) {
+		return fmt.Errorf("not a bear bytecode file")
+	}
+
+	version := binary.BigEndian.Uint16(data[len(Magic):])
+	if version != FormatVersion {
+		return fmt.Errorf("unsupported bytecode version %d, want %d", version, FormatVersion)
+	}
+
+	length := binary.BigEndian.Uint32(data[len(Magic) + 2:])
+	payload := data[headerSize:]
+	if uint64(len(payload)) != uint64(length) {
+		return fmt.Errorf("corrupt bytecode: payload is %d bytes, want %d", len(payload), length)
+	}
+
+	checksum := binary.BigEndian.Uint32(data[len(Magic) + 6:])
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return fmt.Errorf("corrupt bytecode: checksum mismatch")
+	}
+
+	dec := &decoder{data: payload}
+
+	count := dec.count()
+	for i := 0 ; i < count && dec.err == nil ; i++ {
+		dec.filenames = append(dec.filenames, dec.string())
+	}
+
+	bytecode := Bytecode{
+		Instructions: 	dec.bytes(),
+		SourceMap: 		dec.sourceMap(),
+		Constants: 		[]object.Object{},
+	}
+
+	count = dec.count()
+	for i := 0 ; i < count && dec.err == nil ; i++ {
+		bytecode.Constants = append(bytecode.Constants, dec.constant())
+	}
+
+	if dec.err == nil && dec.offset != len(dec.data) {
+		dec.fail("%d bytes of trailing data", len(dec.data) - dec.offset)
+	}
+	if dec.err != nil {
+		return dec.err
+	}
+
+	*self = bytecode
+	return nil
+}
+
+type encoder struct {
+	out 		*bytes.Buffer
+	filenames 	map[string]int
+	names 		[]string // filenames by index
+}
+
+func (self *encoder) collectFilenames(sourceMap code.SourceMap) {
+	for _, offset := range sortedOffsets(sourceMap) {
+		name := sourceMap[offset].Filename
+		if _, ok := self.filenames[name]; !ok {
+			self.filenames[name] = len(self.names)
+			self.names = append(self.names, name)
+		}
+	}
+}
+
+func (self *encoder) uvarint(n int) {
+	var buf [binary.MaxVarintLen64]byte
+	self.out.Write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+}
+
+func (self *encoder) varint(n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	self.out.Write(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func (self *encoder) bytes(b []byte) {
+	self.uvarint(len(b))
+	self.out.Write(b)
+}
+
+func (self *encoder) string(s string) {
+	self.bytes([]byte(s))
+}
+
+// sourceMap writes the entries ordered by offset, so that the same
+// bytecode always serializes to the same bytes.
+func (self *encoder) sourceMap(sourceMap code.SourceMap) {
+	self.uvarint(len(sourceMap))
+	for _, offset := range sortedOffsets(sourceMap) {
+		pos := sourceMap[offset]
+		self.varint(int64(offset))
+		self.uvarint(self.filenames[pos.Filename])
+		self.varint(int64(pos.Offset))
+		self.varint(int64(pos.Line))
+		self.varint(int64(pos.Column))
+	}
+}
+
+func (self *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		self.out.WriteByte(tagInteger)
+		self.varint(obj.Value)
+	case *object.Float:
+		self.out.WriteByte(tagFloat)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(obj.Value))
+		self.out.Write(buf[:])
+	case *object.String:
+		self.out.WriteByte(tagString)
+		self.string(obj.Value)
+	case *object.CompiledFunction:
+		self.out.WriteByte(tagFunction)
+		self.string(obj.Name)
+		self.uvarint(obj.NumLocals)
+		self.uvarint(obj.NumParameters)
+		self.bytes(obj.Instructions)
+		self.sourceMap(obj.SourceMap)
+	default:
+		return fmt.Errorf("cannot serialize %s", obj.Type())
+	}
+	return nil
+}
+
+func sortedOffsets(sourceMap code.SourceMap) []int {
+	offsets := []int{}
+	for offset := range sourceMap {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// decoder reads a payload. The first error is kept in err and every
+// later read returns a zero value, so callers check err once at the end.
+type decoder struct {
+	data 		[]byte
+	offset 		int
+	filenames 	[]string
+	err 		error
+}
+
+func (self *decoder) fail(format string, args ...interface{}) {
+	if self.err == nil {
+		self.err = fmt.Errorf("corrupt bytecode: " + format, args...)
+	}
+}
+
+func (self *decoder) uvarint() uint64 {
+	if self.err != nil {
+		return 0
+	}
+	n, read := binary.Uvarint(self.data[self.offset:])
+	if read <= 0 {
+		self.fail("bad integer at offset %d", self.offset)
+		return 0
+	}
+	self.offset += read
+	return n
+}
+
+func (self *decoder) varint() int64 {
+	if self.err != nil {
+		return 0
+	}
+	n, read := binary.Varint(self.data[self.offset:])
+	if read <= 0 {
+		self.fail("bad integer at offset %d", self.offset)
+		return 0
+	}
+	self.offset += read
+	return n
+}
+
+// count reads a length, which cannot be more than the bytes left since
+// every element takes at least one.
+func (self *decoder) count() int {
+	n := self.uvarint()
+	if n > uint64(len(self.data) - self.offset) {
+		self.fail("length %d exceeds the data left", n)
+		return 0
+	}
+	return int(n)
+}
+
+// small reads a count that has to fit an operand, like a number of locals.
+func (self *decoder) small() int {
+	n := self.uvarint()
+	if n > math.MaxUint16 {
+		self.fail("value %d out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (self *decoder) int() int {
+	n := self.varint()
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		self.fail("value %d out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (self *decoder) bytes() []byte {
+	n := self.count()
+	if self.err != nil {
+		return nil
+	}
+	b := append([]byte{}, self.data[self.offset:self.offset + n]...)
+	self.offset += n
+	return b
+}
+
+func (self *decoder) string() string {
+	return string(self.bytes())
+}
+
+func (self *decoder) sourceMap() code.SourceMap {
+	sourceMap := code.SourceMap{}
+
+	count := self.count()
+	for i := 0 ; i < count && self.err == nil ; i++ {
+		offset := self.int()
+		filename := self.uvarint()
+		if self.err == nil && filename >= uint64(len(self.filenames)) {
+			self.fail("unknown file name %d", filename)
+		}
+		if self.err != nil {
+			break
+		}
+
+		sourceMap[offset] = token.Position{
+			Filename: 	self.filenames[filename],
+			Offset: 	self.int(),
+			Line: 		self.int(),
+			Column: 	self.int(),
+		}
+	}
+
+	return sourceMap
+}
+
+func (self *decoder) constant() object.Object {
+	if self.err != nil {
+		return nil
+	}
+	if self.offset >= len(self.data) {
+		self.fail("missing constant")
+		return nil
+	}
+
+	tag := self.data[self.offset]
+	self.offset++
+
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: self.varint()}
+	case tagFloat:
+		if len(self.data) - self.offset < 8 {
+			self.fail("truncated float")
+			return nil
+		}
+		bits := binary.BigEndian.Uint64(self.data[self.offset:])
+		self.offset += 8
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case tagString:
+		return &object.String{Value: self.string()}
+	case tagFunction:
+		return &object.CompiledFunction{
+			Name: 			self.string(),
+			NumLocals: 		self.small(),
+			NumParameters: 	self.small(),
+			Instructions: 	self.bytes(),
+			SourceMap: 		self.sourceMap(),
+		}
+	default:
+		self.fail("unknown constant tag %q", tag)
+		return nil
+	}
+}
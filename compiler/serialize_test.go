@@ -0,0 +1,153 @@
+package compiler
+
+import (
+	"bear/lexer"
+	"bear/object"
+	"bear/parser"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compileFile(t *testing.T, filename, input string) *Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.NewFile(filename, input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	input := `
+let add = fn(a, b) { let sum = a + b; sum };
+let adder = fn(x) { fn(y) { x + y } };
+let name = "bear";
+puts(add(1, -2), adder(2.5)(0.5), name, [1, 2][0], {"a": 1}["a"]);
+`
+	bytecode := compileFile(t, "script.bear", input)
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	if !IsSerialized(data) {
+		t.Errorf("serialized bytecode does not start with the magic")
+	}
+
+	again, err := bytecode.MarshalBinary()
+	if err != nil || string(again) != string(data) {
+		t.Errorf("serializing the same bytecode twice gave different bytes")
+	}
+
+	loaded := &Bytecode{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(loaded, bytecode) {
+		t.Errorf("bytecode changed in the round trip.\nwant=%#v\ngot=%#v", bytecode, loaded)
+	}
+}
+
+func TestSerializeUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Array{}}}
+
+	_, err := bytecode.MarshalBinary()
+	if err == nil || !strings.Contains(err.Error(), "cannot serialize ARRAY") {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestUnmarshalInvalidBytecode(t *testing.T) {
+	data, err := compileFile(t, "script.bear", `let f = fn(x) { x * 2 }; f(21);`).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	corrupt := func(change func(data []byte) []byte) []byte {
+		return change(append([]byte{}, data...))
+	}
+
+	tests := []struct {
+		data 		[]byte
+		expected 	string
+	}{
+		{[]byte("puts(1)"), "not a bear bytecode file"},
+		{data[:headerSize - 1], "not a bear bytecode file"},
+		{
+			corrupt(func(data []byte) []byte {
+				binary.BigEndian.PutUint16(data[len(Magic):], FormatVersion + 1)
+				return data
+			}),
+			"unsupported bytecode version",
+		},
+		{data[:len(data) - 1], "payload is"},
+		{append(append([]byte{}, data...), 0), "payload is"},
+		{
+			corrupt(func(data []byte) []byte {
+				data[len(data) - 1] ^= 0xff
+				return data
+			}),
+			"checksum mismatch",
+		},
+	}
+
+	for _, test := range tests {
+		err := (&Bytecode{}).UnmarshalBinary(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("wrong error. want=%q, got=%v", test.expected, err)
+		}
+	}
+}
+
+func FuzzUnmarshalBytecode(f *testing.F) {
+	for _, input := range []string{`1 + 2`, `let f = fn(a) { a }; f("x")`, `[1.5, {"a": 2}]`} {
+		p := parser.New(lexer.New(input))
+		compiler := New()
+		if err := compiler.Compile(p.ParseProgram()); err != nil {
+			f.Fatalf("compiler error: %s", err)
+		}
+
+		data, err := compiler.Bytecode().MarshalBinary()
+		if err != nil {
+			f.Fatalf("MarshalBinary failed: %s", err)
+		}
+		f.Add(data[headerSize:])
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		// fix up the header so the fuzzer reaches the payload decoder
+		data := make([]byte, headerSize)
+		copy(data, Magic)
+		binary.BigEndian.PutUint16(data[len(Magic):], FormatVersion)
+		binary.BigEndian.PutUint32(data[len(Magic) + 2:], uint32(len(payload)))
+		binary.BigEndian.PutUint32(data[len(Magic) + 6:], crc32.ChecksumIEEE(payload))
+		data = append(data, payload...)
+
+		bytecode := &Bytecode{}
+		if err := bytecode.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		again, err := bytecode.MarshalBinary()
+		if err != nil {
+			t.Fatalf("loaded bytecode does not serialize: %s", err)
+		}
+
+		loaded := &Bytecode{}
+		if err := loaded.UnmarshalBinary(again); err != nil {
+			t.Fatalf("reserialized bytecode does not load: %s", err)
+		}
+	})
+}
@@ -5,6 +5,9 @@ type SymbolScope string
 const (
 	LocalScope SymbolScope = "LOCAL"
 	GlobalScope SymbolScope = "GLOBAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	FreeScope SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -18,11 +21,21 @@ type SymbolTable struct {
 
 	store 			map[string]Symbol
 	numDefinitions 	int
+
+	FreeSymbols 	[]Symbol
+
+	// symbols removed by Forget, whose slots a new definition reuses
+	forgotten 		map[string]Symbol
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	free := []Symbol{}
+	return &SymbolTable{
+		store: 			s,
+		FreeSymbols: 	free,
+		forgotten: 		make(map[string]Symbol),
+	}
 }
 
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
@@ -31,6 +44,27 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// Copy returns a table with the same bindings whose definitions do not
+// affect this one, for compiling code that may never run.
+func (self *SymbolTable) Copy() *SymbolTable {
+	table := NewSymbolTable()
+	table.Outer = self.Outer
+	table.numDefinitions = self.numDefinitions
+	table.FreeSymbols = append(table.FreeSymbols, self.FreeSymbols...)
+
+	for name, symbol := range self.store {
+		table.store[name] = symbol
+	}
+	for name, symbol := range self.forgotten {
+		table.forgotten[name] = symbol
+	}
+
+	return table
+}
+
+// Define binds name in this table. Redefining a name that is already
+// bound in the same table reuses its slot, so a let inside a loop body
+// updates the variable the loop condition reads.
 func (self *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: self.numDefinitions}
 	if self.Outer == nil {
@@ -38,6 +72,17 @@ func (self *SymbolTable) Define(name string) Symbol {
 	} else {
 		symbol.Scope = LocalScope
 	}
+
+	if existing, ok := self.store[name]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
+
+	if forgotten, ok := self.forgotten[name]; ok && forgotten.Scope == symbol.Scope {
+		delete(self.forgotten, name)
+		self.store[name] = forgotten
+		return forgotten
+	}
+
 	self.store[name] = symbol
 	self.numDefinitions++
 	return symbol
@@ -46,8 +91,56 @@ func (self *SymbolTable) Define(name string) Symbol {
 func (self *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := self.store[name]
 	if !ok && self.Outer != nil {
-		obj, ok := self.Outer.Resolve(name)
-		return obj, ok
+		obj, ok = self.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		free := self.defineFree(obj)
+		return free, true
 	}
 	return obj, ok
-}
\ No newline at end of file
+}
+
+func (self *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	self.store[name] = symbol
+	return symbol
+}
+
+func (self *SymbolTable) defineFree(original Symbol) Symbol {
+	self.FreeSymbols = append(self.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(self.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	self.store[original.Name] = symbol
+	return symbol
+}
+
+func (self *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	self.store[name] = symbol
+	return symbol
+}
+
+// Forget removes the symbols defined in this table for which forget
+// returns true, like globals whose let statement never ran. Defining a
+// forgotten name again gives it back its slot, so code compiled while it
+// was defined sees the new value.
+func (self *SymbolTable) Forget(forget func(Symbol) bool) {
+	for name, symbol := range self.store {
+		if symbol.Scope != GlobalScope && symbol.Scope != LocalScope {
+			continue
+		}
+
+		if forget(symbol) {
+			self.forgotten[name] = symbol
+			delete(self.store, name)
+		}
+	}
+}
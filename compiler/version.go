@@ -0,0 +1,45 @@
+package compiler
+
+import (
+	"bear/lexer"
+	"bear/parser"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+)
+
+//go:embed version.bear
+var versionCorpus string
+
+// Version identifies the code the compiler generates. Caches of compiled
+// scripts are keyed by it. It is a hash of the bytecode versionCorpus
+// compiles to, with and without optimizations, so it changes by itself
+// whenever the compiler starts emitting different instructions.
+var Version = version()
+
+func version() string {
+	hash := sha256.New()
+
+	for _, optimize := range []bool{false, true} {
+		par := parser.New(lexer.New(versionCorpus))
+		program := par.ParseProgram()
+		if len(par.Errors()) != 0 {
+			panic(fmt.Sprintf("compiler: version corpus: %s", par.Errors()[0]))
+		}
+
+		comp := New()
+		comp.Optimize = optimize
+		if err := comp.Compile(program); err != nil {
+			panic(fmt.Sprintf("compiler: version corpus: %s", err))
+		}
+
+		data, err := comp.Bytecode().MarshalBinary()
+		if err != nil {
+			panic(fmt.Sprintf("compiler: version corpus: %s", err))
+		}
+		hash.Write(data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
@@ -0,0 +1,54 @@
+package compiler
+
+import (
+	"bear/code"
+	"bear/object"
+	"testing"
+)
+
+// The version corpus has to use every opcode, or a change to how the
+// compiler emits a missing one would not change Version.
+func TestVersionCorpusOpcodes(t *testing.T) {
+	used := map[code.Opcode]bool{}
+
+	for _, optimize := range []bool{false, true} {
+		comp := New()
+		comp.Optimize = optimize
+		if err := comp.Compile(parse(versionCorpus)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		instructions := []code.Instructions{bytecode.Instructions}
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				instructions = append(instructions, fn.Instructions)
+			}
+		}
+
+		for _, ins := range instructions {
+			for i := 0 ; i < len(ins) ; {
+				def, err := code.Lookup(ins[i])
+				if err != nil {
+					t.Fatalf("%s", err)
+				}
+				used[code.Opcode(ins[i])] = true
+				i += def.InstructionWidth()
+			}
+		}
+	}
+
+	for op := 0 ; op < 256 ; op++ {
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			continue
+		}
+		if !used[code.Opcode(op)] {
+			t.Errorf("the version corpus does not use %s", def.Name)
+		}
+	}
+
+	if len(Version) != 16 {
+		t.Errorf("wrong Version %q", Version)
+	}
+}
@@ -0,0 +1,142 @@
+// Package conformance runs Bear programs on both the tree-walking
+// evaluator and the bytecode VM so their behaviour can be compared, both
+// hand-written programs in testdata and randomly generated ones.
+package conformance
+
+import (
+	"bear/ast"
+	"bear/compiler"
+	"bear/evaluator"
+	"bear/lexer"
+	"bear/object"
+	"bear/parser"
+	"bear/vm"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Result is what running a program produced: everything it printed and
+// the error that stopped it, if any.
+type Result struct {
+	Output 	string
+	Error 	string
+}
+
+func (self Result) String() string {
+	if self.Error == "" {
+		return self.Output
+	}
+	return self.Output + "error: " + self.Error + "\n"
+}
+
+// Engine runs a program's source and reports what it produced.
+type Engine func(source string) Result
+
+// Engines are the engines every conformance program is run on, by name.
+var Engines = map[string]Engine{
+	"eval": 			RunEval,
+	"vm": 				RunVM,
+	"vm-optimized": 	RunOptimizedVM,
+}
+
+// RunEval runs source on the tree-walking evaluator.
+func RunEval(source string) Result {
+	return run(func() error {
+		program, err := parse(source)
+		if err != nil {
+			return err
+		}
+
+		obj := evaluator.Eval(program, object.NewEnvironment())
+		if err, ok := obj.(*object.Error); ok {
+			return err
+		}
+		return nil
+	})
+}
+
+// RunVM compiles source and runs it on the VM.
+func RunVM(source string) Result {
+	return runVM(source, false)
+}
+
+// RunOptimizedVM compiles source with the compiler's optimizations and
+// runs it on the VM.
+func RunOptimizedVM(source string) Result {
+	return runVM(source, true)
+}
+
+func runVM(source string, optimize bool) Result {
+	return run(func() error {
+		program, err := parse(source)
+		if err != nil {
+			return err
+		}
+
+		bytecode, err := compile(program, optimize)
+		if err != nil {
+			return err
+		}
+
+		// the compiler's output must always pass the verifier
+		if err := vm.Verify(bytecode); err != nil {
+			return fmt.Errorf("invalid bytecode: %s", err)
+		}
+
+		machine := vm.New(bytecode)
+		return machine.Run()
+	})
+}
+
+func compile(program *ast.Program, optimize bool) (*compiler.Bytecode, error) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.Optimize = optimize
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	return comp.Bytecode(), nil
+}
+
+// run captures what execute prints and turns a panic into an error, so
+// a crashing engine shows up as a mismatch instead of stopping the suite.
+func run(execute func() error) (result Result) {
+	var out bytes.Buffer
+
+	saved := object.Output
+	object.Output = &out
+
+	defer func() {
+		object.Output = saved
+		result.Output = out.String()
+
+		if r := recover(); r != nil {
+			result.Error = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+
+	err := execute()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func parse(source string) (*ast.Program, error) {
+	par := parser.New(lexer.New(source))
+
+	program := par.ParseProgram()
+	if len(par.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(par.Errors(), "\n"))
+	}
+
+	return program, nil
+}
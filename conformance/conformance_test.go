@@ -0,0 +1,115 @@
+package conformance
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Run "go test ./conformance -update" to rewrite the golden files from
+// the engines' current behaviour. Golden files are only written for
+// programs on which every engine agrees.
+var update = flag.Bool("update", false, "rewrite the .out and .err golden files")
+
+func TestConformance(t *testing.T) {
+	programs, err := filepath.Glob(filepath.Join("testdata", "*.bear"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) == 0 {
+		t.Fatal("no conformance programs in testdata")
+	}
+
+	names := []string{}
+	for name := range Engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, program := range programs {
+		program := program
+		t.Run(strings.TrimSuffix(filepath.Base(program), ".bear"), func(t *testing.T) {
+			source, err := ioutil.ReadFile(program)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := map[string]Result{}
+			for _, name := range names {
+				results[name] = Engines[name](string(source))
+			}
+
+			first := results[names[0]]
+			agree := true
+			for _, name := range names[1:] {
+				if results[name] != first {
+					agree = false
+					t.Errorf("engines disagree.\n%s:\n%s\n%s:\n%s", names[0], first, name, results[name])
+				}
+			}
+
+			base := strings.TrimSuffix(program, ".bear")
+			if *update {
+				if agree {
+					writeGolden(t, base, first)
+				}
+				return
+			}
+
+			expected := readGolden(t, base)
+			for _, name := range names {
+				if results[name] != expected {
+					t.Errorf("%s: wrong result.\nwant:\n%s\ngot:\n%s", name, expected, results[name])
+				}
+			}
+		})
+	}
+}
+
+// readGolden reads the expected output of program base from base.out and
+// its expected error from base.err, which only exists if it fails.
+func readGolden(t *testing.T, base string) Result {
+	t.Helper()
+
+	output, err := ioutil.ReadFile(base + ".out")
+	if err != nil {
+		t.Fatalf("missing golden file, run with -update: %s", err)
+	}
+
+	expected := Result{Output: string(output)}
+
+	message, err := ioutil.ReadFile(base + ".err")
+	if err == nil {
+		expected.Error = strings.TrimSuffix(string(message), "\n")
+	} else if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	return expected
+}
+
+func writeGolden(t *testing.T, base string, result Result) {
+	t.Helper()
+
+	err := ioutil.WriteFile(base + ".out", []byte(result.Output), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Error == "" {
+		err = os.Remove(base + ".err")
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	err = ioutil.WriteFile(base + ".err", []byte(result.Error + "\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,401 @@
+package conformance
+
+import (
+	"bear/ast"
+	"bear/token"
+	"math/rand"
+	"strconv"
+)
+
+// kind is the type of value the generator means an expression to have.
+// Now and then it picks the wrong one on purpose, so that both engines'
+// error handling is exercised too.
+type kind int
+
+const (
+	kindInt kind = iota
+	kindBool
+	kindString
+	kindArray
+	kindHash
+	kindFunction
+)
+
+// valueKinds are the kinds that may be printed or stored; functions are
+// left out since the engines name their types differently.
+var valueKinds = []kind{kindInt, kindBool, kindString, kindArray, kindHash}
+
+type variable struct {
+	name 	string
+	kind 	kind
+	params 	[]kind // for functions
+	result 	kind // for functions
+}
+
+// maxDepth limits how deeply expressions are nested.
+const maxDepth = 4
+
+// Generator builds random well-formed programs from integers, strings,
+// arrays, hashes, ifs, functions and calls. Functions only call functions
+// defined before them, so every generated program terminates.
+type Generator struct {
+	rand 	*rand.Rand
+	scope 	[]variable
+	depth 	int
+	names 	int
+}
+
+func NewGenerator(seed int64) *Generator {
+	return &Generator{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Program generates a new program that prints what it computes.
+func (self *Generator) Program() *ast.Program {
+	self.scope = nil
+	program := &ast.Program{}
+
+	count := 1 + self.rand.Intn(8)
+	for i := 0 ; i < count ; i++ {
+		switch n := self.rand.Intn(10); {
+		case n < 5:
+			program.Statements = append(program.Statements, self.letStatement())
+		case n < 9:
+			program.Statements = append(program.Statements, self.putsStatement())
+		default:
+			program.Statements = append(program.Statements, expressionStatement(self.expression(self.valueKind())))
+		}
+	}
+
+	program.Statements = append(program.Statements, self.putsStatement())
+	return program
+}
+
+func (self *Generator) valueKind() kind {
+	return valueKinds[self.rand.Intn(len(valueKinds))]
+}
+
+// newName returns a fresh name; identifiers cannot contain digits, so
+// the counter is spelled in letters.
+func (self *Generator) newName(prefix string) string {
+	name := []byte(prefix)
+	for n := self.names ; ; n = n / 26 - 1 {
+		name = append(name, byte('a' + n % 26))
+		if n < 26 {
+			break
+		}
+	}
+	self.names++
+	return string(name)
+}
+
+// letStatement binds a new variable, a function a third of the time.
+func (self *Generator) letStatement() *ast.LetStatement {
+	v := variable{name: self.newName("v")}
+
+	var value ast.Expression
+	if self.rand.Intn(3) == 0 {
+		v.kind = kindFunction
+		value, v.params, v.result = self.functionLiteral()
+	} else {
+		v.kind = self.valueKind()
+		value = self.expression(v.kind)
+	}
+
+	// bound after generating the value, so it cannot refer to itself
+	self.scope = append(self.scope, v)
+
+	return &ast.LetStatement{
+		Token: 	token.Token{Type: token.LET, Literal: "let"},
+		Name: 	identifier(v.name),
+		Value: 	value,
+	}
+}
+
+func (self *Generator) putsStatement() *ast.ExpressionStatement {
+	args := []ast.Expression{self.expression(self.valueKind())}
+	if self.rand.Intn(4) == 0 {
+		args = append(args, self.expression(self.valueKind()))
+	}
+	return expressionStatement(call(identifier("puts"), args...))
+}
+
+// expression generates an expression that is meant to evaluate to k.
+func (self *Generator) expression(k kind) ast.Expression {
+	self.depth++
+	defer func() { self.depth-- }()
+
+	if self.rand.Intn(12) == 0 {
+		k = self.valueKind()
+	}
+
+	if self.depth >= maxDepth || self.rand.Intn(4) == 0 {
+		return self.leaf(k)
+	}
+
+	switch k {
+	case kindInt:
+		switch self.rand.Intn(8) {
+		case 0, 1, 2:
+			operators := []string{"+", "-", "*", "/", "%", "**"}
+			return infix(self.expression(kindInt), operators[self.rand.Intn(len(operators))], self.expression(kindInt))
+		case 3:
+			return prefix("-", self.expression(kindInt))
+		case 4:
+			return self.ifExpression(kindInt)
+		case 5:
+			return self.call(kindInt)
+		case 6:
+			if self.rand.Intn(2) == 0 {
+				return call(identifier("len"), self.expression(kindString))
+			}
+			return call(identifier("len"), self.expression(kindArray))
+		default:
+			if self.rand.Intn(2) == 0 {
+				return index(self.expression(kindArray), self.expression(kindInt))
+			}
+			return index(self.expression(kindHash), self.hashKey())
+		}
+
+	case kindBool:
+		switch self.rand.Intn(5) {
+		case 0, 1:
+			operators := []string{"<", ">", "<=", ">=", "==", "!="}
+			operand := kindInt
+			if self.rand.Intn(3) == 0 {
+				operand = kindString
+			}
+			return infix(self.expression(operand), operators[self.rand.Intn(len(operators))], self.expression(operand))
+		case 2:
+			return prefix("!", self.expression(kindBool))
+		case 3:
+			operators := []string{"&&", "||"}
+			return infix(self.expression(kindBool), operators[self.rand.Intn(2)], self.expression(kindBool))
+		default:
+			return self.call(kindBool)
+		}
+
+	case kindString:
+		switch self.rand.Intn(3) {
+		case 0:
+			return infix(self.expression(kindString), "+", self.expression(kindString))
+		case 1:
+			return self.ifExpression(kindString)
+		default:
+			return self.call(kindString)
+		}
+
+	case kindArray:
+		switch self.rand.Intn(4) {
+		case 0, 1:
+			elements := []ast.Expression{}
+			for i := self.rand.Intn(4) ; i > 0 ; i-- {
+				elements = append(elements, self.expression(kindInt))
+			}
+			return array(elements...)
+		case 2:
+			return call(identifier("push"), self.expression(kindArray), self.expression(self.valueKind()))
+		default:
+			return call(identifier("tail"), self.expression(kindArray))
+		}
+
+	case kindHash:
+		// the literal's pairs are unordered, so a repeated key would make
+		// which value wins depend on map iteration
+		pairs := map[ast.Expression]ast.Expression{}
+		seen := map[string]bool{}
+		for i := self.rand.Intn(4) ; i > 0 ; i-- {
+			key := self.hashKey()
+			if !seen[key.String()] {
+				seen[key.String()] = true
+				pairs[key] = self.expression(self.valueKind())
+			}
+		}
+		return &ast.HashLiteral{Token: token.Token{Type: token.LBRACE, Literal: "{"}, Pairs: pairs}
+	}
+
+	return self.leaf(k)
+}
+
+// leaf generates a literal or a variable of kind k.
+func (self *Generator) leaf(k kind) ast.Expression {
+	candidates := []variable{}
+	for _, v := range self.scope {
+		if v.kind == k {
+			candidates = append(candidates, v)
+		}
+	}
+	if len(candidates) > 0 && self.rand.Intn(2) == 0 {
+		return identifier(candidates[self.rand.Intn(len(candidates))].name)
+	}
+
+	switch k {
+	case kindInt:
+		if self.rand.Intn(20) == 0 {
+			return integer(9223372036854775807)
+		}
+		return integer(int64(self.rand.Intn(100)))
+	case kindBool:
+		return boolean(self.rand.Intn(2) == 0)
+	case kindString:
+		return self.stringLiteral()
+	case kindArray:
+		elements := []ast.Expression{}
+		for i := self.rand.Intn(3) ; i > 0 ; i-- {
+			elements = append(elements, integer(int64(self.rand.Intn(10))))
+		}
+		return array(elements...)
+	default:
+		return &ast.HashLiteral{
+			Token: token.Token{Type: token.LBRACE, Literal: "{"},
+			Pairs: map[ast.Expression]ast.Expression{self.hashKey(): integer(1)},
+		}
+	}
+}
+
+func (self *Generator) stringLiteral() *ast.StringLiteral {
+	words := []string{"", "a", "b", "bear", "hello world"}
+	s := words[self.rand.Intn(len(words))]
+	return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: s}, Value: s}
+}
+
+func (self *Generator) hashKey() ast.Expression {
+	if self.rand.Intn(2) == 0 {
+		return integer(int64(self.rand.Intn(4)))
+	}
+	return self.stringLiteral()
+}
+
+func (self *Generator) ifExpression(k kind) ast.Expression {
+	expression := &ast.IfExpression{
+		Token: 			token.Token{Type: token.IF, Literal: "if"},
+		Condition: 		self.expression(kindBool),
+		Consequence: 	block(expressionStatement(self.expression(k))),
+	}
+
+	if self.rand.Intn(4) != 0 {
+		expression.Alternative = block(expressionStatement(self.expression(k)))
+	}
+
+	return expression
+}
+
+// call calls a function in scope that returns k, or a new function
+// literal if there is none.
+func (self *Generator) call(k kind) ast.Expression {
+	candidates := []variable{}
+	for _, v := range self.scope {
+		if v.kind == kindFunction && v.result == k {
+			candidates = append(candidates, v)
+		}
+	}
+
+	var function ast.Expression
+	var params []kind
+
+	if len(candidates) > 0 && self.rand.Intn(4) != 0 {
+		v := candidates[self.rand.Intn(len(candidates))]
+		function, params = identifier(v.name), v.params
+	} else {
+		function, params, _ = self.functionLiteralReturning(k)
+	}
+
+	args := []ast.Expression{}
+	for _, param := range params {
+		args = append(args, self.expression(param))
+	}
+
+	// occasionally call with the wrong number of arguments
+	if self.rand.Intn(30) == 0 {
+		args = append(args, integer(0))
+	}
+
+	return call(function, args...)
+}
+
+func (self *Generator) functionLiteral() (*ast.FunctionLiteral, []kind, kind) {
+	return self.functionLiteralReturning(self.valueKind())
+}
+
+// functionLiteralReturning generates a function that returns result and
+// may use its parameters and the variables already in scope.
+func (self *Generator) functionLiteralReturning(result kind) (*ast.FunctionLiteral, []kind, kind) {
+	outer := self.scope
+	self.scope = append([]variable{}, outer...)
+	defer func() { self.scope = outer }()
+
+	function := &ast.FunctionLiteral{Token: token.Token{Type: token.FUNCTION, Literal: "fn"}}
+	params := []kind{}
+
+	for i := self.rand.Intn(4) ; i > 0 ; i-- {
+		param := variable{name: self.newName("p"), kind: self.valueKind()}
+		self.scope = append(self.scope, param)
+		params = append(params, param.kind)
+		function.Parameters = append(function.Parameters, identifier(param.name))
+	}
+
+	body := []ast.Statement{}
+	for i := self.rand.Intn(3) ; i > 0 ; i-- {
+		body = append(body, self.letStatement())
+	}
+
+	if self.rand.Intn(3) == 0 {
+		early := &ast.IfExpression{
+			Token: 			token.Token{Type: token.IF, Literal: "if"},
+			Condition: 		self.expression(kindBool),
+			Consequence: 	block(&ast.ReturnStatement{
+				Token: 			token.Token{Type: token.RETURN, Literal: "return"},
+				ReturnValue: 	self.expression(result),
+			}),
+		}
+		body = append(body, expressionStatement(early))
+	}
+
+	body = append(body, expressionStatement(self.expression(result)))
+	function.Body = block(body...)
+
+	return function, params, result
+}
+
+func identifier(name string) *ast.Identifier {
+	return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+func integer(value int64) *ast.IntegerLiteral {
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}, Value: value}
+}
+
+func boolean(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
+
+func array(elements ...ast.Expression) *ast.ArrayLiteral {
+	return &ast.ArrayLiteral{Token: token.Token{Type: token.LBRACKET, Literal: "["}, Elements: elements}
+}
+
+func index(left, index ast.Expression) *ast.IndexExpression {
+	return &ast.IndexExpression{Token: token.Token{Type: token.LBRACKET, Literal: "["}, Left: left, Index: index}
+}
+
+func prefix(operator string, right ast.Expression) *ast.PrefixExpression {
+	return &ast.PrefixExpression{Token: token.Token{Literal: operator}, Operator: operator, Right: right}
+}
+
+func infix(left ast.Expression, operator string, right ast.Expression) *ast.InfixExpression {
+	return &ast.InfixExpression{Token: token.Token{Literal: operator}, Left: left, Operator: operator, Right: right}
+}
+
+func call(function ast.Expression, args ...ast.Expression) *ast.CallExpression {
+	return &ast.CallExpression{Token: token.Token{Type: token.LPAREN, Literal: "("}, Function: function, Arguments: args}
+}
+
+func block(stmts ...ast.Statement) *ast.BlockStatement {
+	return &ast.BlockStatement{Token: token.Token{Type: token.LBRACE, Literal: "{"}, Statements: stmts}
+}
+
+func expressionStatement(expression ast.Expression) *ast.ExpressionStatement {
+	return &ast.ExpressionStatement{Expression: expression}
+}
@@ -0,0 +1,96 @@
+package conformance
+
+import (
+	"bear/ast"
+	"strings"
+	"testing"
+)
+
+// divergence prints program, re-parses it and runs it on the evaluator
+// and the VM, with and without optimizations, reporting the results of
+// the first VM that disagrees with the evaluator. Programs the compiler
+// rejects are not compared: the VM reports undefined names before running
+// anything, the evaluator only once it reaches them.
+func divergence(program *ast.Program) (Result, Result, bool) {
+	source := program.String()
+
+	parsed, err := parse(source)
+	if err != nil {
+		return Result{}, Result{}, false
+	}
+	if _, err := compile(parsed, false); err != nil {
+		return Result{}, Result{}, false
+	}
+
+	eval := RunEval(source)
+	for _, engine := range []Engine{RunVM, RunOptimizedVM} {
+		if vm := engine(source); vm != eval {
+			return eval, vm, true
+		}
+	}
+	return eval, Result{}, false
+}
+
+func checkGenerated(t *testing.T, seed int64) {
+	t.Helper()
+
+	program := NewGenerator(seed).Program()
+	source := program.String()
+
+	parsed, err := parse(source)
+	if err != nil {
+		t.Fatalf("seed %d: generated program does not parse: %s\n%s", seed, err, source)
+	}
+	if parsed.String() != source {
+		t.Fatalf("seed %d: program does not print the way it parses.\nwant=%s\ngot=%s", seed, source, parsed.String())
+	}
+
+	if _, _, diverged := divergence(program); !diverged {
+		return
+	}
+
+	Shrink(program, func(program *ast.Program) bool {
+		_, _, diverged := divergence(program)
+		return diverged
+	})
+
+	eval, vm, _ := divergence(program)
+	t.Errorf("seed %d: engines disagree on\n%s\neval:\n%s\nvm:\n%s", seed, program, eval, vm)
+}
+
+func TestRandomPrograms(t *testing.T) {
+	count := int64(500)
+	if testing.Short() {
+		count = 50
+	}
+
+	for seed := int64(0) ; seed < count ; seed++ {
+		checkGenerated(t, seed)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	program, err := parse(`let a = 1; let b = [2, 3]; puts(a + b[0]); puts(10 / (a - 1));`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Shrink(program, func(program *ast.Program) bool {
+		return strings.Contains(RunEval(program.String()).Error, "division by zero")
+	})
+
+	expected := "(0 / 0)"
+	if program.String() != expected {
+		t.Errorf("wrong shrunk program. want=%q, got=%q", expected, program.String())
+	}
+}
+
+func FuzzEngines(f *testing.F) {
+	for seed := int64(0) ; seed < 10 ; seed++ {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64) {
+		checkGenerated(t, seed)
+	})
+}
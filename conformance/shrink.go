@@ -0,0 +1,142 @@
+package conformance
+
+import (
+	"bear/ast"
+)
+
+// Shrink reduces program, in place, to a smaller program for which
+// failing still holds, by deleting statements and replacing expressions
+// with one of their operands or with 0. It stops when no single change
+// keeps failing true.
+func Shrink(program *ast.Program, failing func(*ast.Program) bool) *ast.Program {
+	for shrinkOnce(program, failing) {
+	}
+	return program
+}
+
+// shrinkOnce makes the first change that keeps failing true and reports
+// whether it found one.
+func shrinkOnce(program *ast.Program, failing func(*ast.Program) bool) bool {
+	var s shrinker
+	s.statements(&program.Statements)
+
+	for _, list := range s.lists {
+		for i := len(*list) - 1 ; i >= 0 ; i-- {
+			saved := *list
+			*list = append(append([]ast.Statement{}, saved[:i]...), saved[i + 1:]...)
+			if failing(program) {
+				return true
+			}
+			*list = saved
+		}
+	}
+
+	for _, slot := range s.slots {
+		saved := *slot
+		for _, candidate := range replacements(saved) {
+			if candidate.String() == saved.String() {
+				continue
+			}
+			*slot = candidate
+			if failing(program) {
+				return true
+			}
+		}
+		*slot = saved
+	}
+
+	return false
+}
+
+// shrinker collects the places in a program that can be made smaller:
+// statement lists and the expressions they contain.
+type shrinker struct {
+	lists 	[]*[]ast.Statement
+	slots 	[]*ast.Expression
+}
+
+func (self *shrinker) statements(list *[]ast.Statement) {
+	self.lists = append(self.lists, list)
+
+	for _, stmt := range *list {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			self.expression(&stmt.Value)
+		case *ast.ReturnStatement:
+			self.expression(&stmt.ReturnValue)
+		case *ast.ExpressionStatement:
+			self.expression(&stmt.Expression)
+		case *ast.WhileStatement:
+			self.expression(&stmt.Condition)
+			self.block(stmt.Body)
+		case *ast.ForStatement:
+			self.expression(&stmt.Iterable)
+			self.block(stmt.Body)
+		}
+	}
+}
+
+func (self *shrinker) block(block *ast.BlockStatement) {
+	if block != nil {
+		self.statements(&block.Statements)
+	}
+}
+
+func (self *shrinker) expression(slot *ast.Expression) {
+	if *slot == nil {
+		return
+	}
+	self.slots = append(self.slots, slot)
+
+	switch node := (*slot).(type) {
+	case *ast.PrefixExpression:
+		self.expression(&node.Right)
+	case *ast.InfixExpression:
+		self.expression(&node.Left)
+		self.expression(&node.Right)
+	case *ast.IfExpression:
+		self.expression(&node.Condition)
+		self.block(node.Consequence)
+		self.block(node.Alternative)
+	case *ast.FunctionLiteral:
+		self.block(node.Body)
+	case *ast.CallExpression:
+		self.expression(&node.Function)
+		for i := range node.Arguments {
+			self.expression(&node.Arguments[i])
+		}
+	case *ast.ArrayLiteral:
+		for i := range node.Elements {
+			self.expression(&node.Elements[i])
+		}
+	case *ast.IndexExpression:
+		self.expression(&node.Left)
+		self.expression(&node.Index)
+	case *ast.AssignExpression:
+		self.expression(&node.Value)
+	}
+}
+
+// replacements are the smaller expressions to try in place of node.
+func replacements(node ast.Expression) []ast.Expression {
+	candidates := []ast.Expression{}
+
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		candidates = append(candidates, node.Right)
+	case *ast.InfixExpression:
+		candidates = append(candidates, node.Left, node.Right)
+	case *ast.CallExpression:
+		candidates = append(candidates, node.Arguments...)
+	case *ast.ArrayLiteral:
+		candidates = append(candidates, node.Elements...)
+	case *ast.IndexExpression:
+		candidates = append(candidates, node.Left, node.Index)
+	case *ast.HashLiteral:
+		for _, key := range node.Keys() {
+			candidates = append(candidates, key, node.Pairs[key])
+		}
+	}
+
+	return append(candidates, integer(0))
+}
@@ -3,107 +3,158 @@ package evaluator
 import (
 	"bear/ast"
 	"bear/object"
+	"bear/token"
 	"fmt"
+	"math"
+	"strings"
 )
 
+// MaxFrames limits how deeply function calls nest, counting the program
+// itself as on the VM.
+const MaxFrames = 1024
+
 var (
 	NULL = &object.Null{}
 	TRUE = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	// UNINITIALIZED is bound to a function declared ahead of its let
+	// statement until the let runs. Reading it is an error.
+	UNINITIALIZED = &object.Error{Message: "uninitialized variable"}
 )
 
+// Evaluator runs programs by walking their syntax tree.
+type Evaluator struct {
+	// CheckOverflow makes integer arithmetic that overflows int64 an
+	// error instead of wrapping around.
+	CheckOverflow 	bool
+
+	depth 			int // number of function calls being evaluated
+}
+
+func New() *Evaluator {
+	return &Evaluator{}
+}
+
+// Eval evaluates node in env on a new Evaluator.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+func (self *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
 
 	switch node := node.(type) {
 	// MARK: -- statements
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		return self.evalProgram(node.Statements, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return self.Eval(node.Expression, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return self.evalBlockStatement(node, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := self.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := self.Eval(node.Value, env)
 		if isError(val) { return val }
 		env.Set(node.Name.Value, val)
 
+	case *ast.WhileStatement:
+		return self.evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return self.evalForStatement(node, env)
+
+	case *ast.BreakStatement:
+		return &object.Break{}
+
+	case *ast.ContinueStatement:
+		return &object.Continue{}
+
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return withPosition(evalIdentifier(node, env), node.Token.Pos)
 
 	// MARK: -- expressions
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := self.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(evalPrefixExpression(node.Operator, right), node.Token.Pos)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		if node.Operator == "&&" || node.Operator == "||" {
+			return self.evalLogicalExpression(node, env)
+		}
+
+		left := self.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := self.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return withPosition(self.evalInfixExpression(node.Operator, left, right), node.Token.Pos)
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return self.evalIfExpression(node, env)
+
+	case *ast.AssignExpression:
+		return withPosition(self.evalAssignExpression(node, env), node.Token.Pos)
 
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Env: env, Body: body, Name: node.Name}
 
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := self.Eval(node.Function, env)
 		if isError(function) { return function }
-		args := evalExpressions(node.Arguments, env)
+		args := self.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) { return args[0] }
 
-		return applyFunction(function, args)
+		return withPosition(self.applyFunction(function, args), node.Token.Pos)
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := self.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := self.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := self.Eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return withPosition(evalIndexExpression(left, index), node.Token.Pos)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return withPosition(self.evalHashLiteral(node, env), node.Token.Pos)
 	}
 
 	return nil
@@ -118,11 +169,13 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 }
 
 
-func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
+func (self *Evaluator) evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
+	declareFunctions(stmts, env)
+
 	for _, statement := range stmts {
-		result = Eval(statement, env)
+		result = self.Eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -146,23 +199,49 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func (self *Evaluator) evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return self.evalIntegerInfixExpression(operator, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// evalLogicalExpression evaluates the right operand of && and || only if
+// the left one does not decide the result already.
+func (self *Evaluator) evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := self.Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := self.Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalBangOperatorExpression(right object.Object) object.Object {
 	switch right {
 	case TRUE:
@@ -177,30 +256,53 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+func (self *Evaluator) evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	var result int64
+	var overflow bool
+
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		result, overflow = object.AddInt(leftVal, rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		result, overflow = object.SubInt(leftVal, rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		result, overflow = object.MulInt(leftVal, rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		result, overflow = object.DivInt(leftVal, rightVal)
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		result = leftVal % rightVal
+	case "**":
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		result, overflow = object.PowInt(leftVal, rightVal)
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -208,22 +310,67 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+
+	if overflow && self.CheckOverflow {
+		return newError("integer overflow: %d %s %d", leftVal, operator, rightVal)
+	}
+
+	return &object.Integer{Value: result}
 }
 
-func evalIfExpression(expression *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(expression.Condition, env)
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := object.ToFloat(left)
+	rightVal := object.ToFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (self *Evaluator) evalIfExpression(expression *ast.IfExpression, env *object.Environment) object.Object {
+	condition := self.Eval(expression.Condition, env)
 
 	if isError(condition) {
 		return condition
 	}
 
+	var result object.Object
 	if isTruthy(condition) {
-		return Eval(expression.Consequence, env)
+		result = self.Eval(expression.Consequence, env)
 	} else if expression.Alternative != nil {
-		return Eval(expression.Alternative, env)
-	} else {
+		result = self.Eval(expression.Alternative, env)
+	}
+
+	// a branch without a value, such as an empty one, is null
+	if result == nil {
 		return NULL
 	}
+	return result
 }
 
 func isTruthy(obj object.Object) bool {
@@ -239,14 +386,17 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (self *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
+	declareFunctions(block.Statements, env)
+
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = self.Eval(statement, env)
 
 		if result != nil {
-			if result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -254,10 +404,95 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// declareFunctions declares every function bound by a let statement in
+// stmts up front, like the compiler does, so functions can refer to each
+// other regardless of the order they are written in.
+func declareFunctions(stmts []ast.Statement, env *object.Environment) {
+	for _, s := range stmts {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			env.Declare(let.Name.Value, UNINITIALIZED)
+		}
+	}
+}
+
+func (self *Evaluator) evalWhileStatement(stmt *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := self.Eval(stmt.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		result, done := self.evalLoopBody(stmt.Body, env)
+		if done {
+			return result
+		}
+	}
+}
+
+func (self *Evaluator) evalForStatement(stmt *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := self.Eval(stmt.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	elements, ok := object.Iterate(iterable)
+	if !ok {
+		return withPosition(newError("cannot iterate over %s", iterable.Type()), stmt.Iterable.Pos())
+	}
+
+	for _, element := range elements {
+		env.Set(stmt.Variable.Value, element)
+
+		result, done := self.evalLoopBody(stmt.Body, env)
+		if done {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// evalLoopBody runs one iteration of a loop and reports whether the loop
+// is done, either because of a break or because the result has to
+// propagate further out.
+func (self *Evaluator) evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := self.Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return nil, true
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// withPosition attributes obj to pos if it is an error that has not been
+// attributed to a more specific position yet.
+func withPosition(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -265,8 +500,114 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// evalAssignExpression evaluates an assignment from left to right: a
+// compound assignment reads the variable before evaluating the value, like
+// the VM does.
+func (self *Evaluator) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	if target, ok := node.Target.(*ast.IndexExpression); ok {
+		return self.evalIndexAssignExpression(node, target, env)
+	}
+
+	name := node.Target.(*ast.Identifier).Value
+
+	current, ok := env.Get(name)
+	if !ok {
+		if _, ok := builtins[name]; ok {
+			return newError("cannot assign to builtin %s", name)
+		}
+		return newError("assignment to undeclared variable %s", name)
+	}
+
+	if node.Operator != "=" && current == UNINITIALIZED {
+		return newError("uninitialized variable")
+	}
+
+	val := self.Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if node.Operator != "=" {
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = self.evalInfixExpression(operator, current, val)
+		if isError(val) {
+			return val
+		}
+	}
+
+	env.Assign(name, val)
+	return val
+}
+
+func (self *Evaluator) evalIndexAssignExpression(node *ast.AssignExpression, target *ast.IndexExpression, env *object.Environment) object.Object {
+	left := self.Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	index := self.Eval(target.Index, env)
+	if isError(index) {
+		return index
+	}
+
+	var current object.Object
+	if node.Operator != "=" {
+		current = evalIndexExpression(left, index)
+		if isError(current) {
+			return current
+		}
+	}
+
+	val := self.Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if node.Operator != "=" {
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = self.evalInfixExpression(operator, current, val)
+		if isError(val) {
+			return val
+		}
+	}
+
+	return evalSetIndex(left, index, val)
+}
+
+func evalSetIndex(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("array index out of range: %d (length %d)", i.Value, len(left.Elements))
+		}
+
+		left.Elements[i.Value] = val
+		return val
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return val
+
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
+		if val == UNINITIALIZED {
+			return newError("uninitialized variable")
+		}
 		return val
 	}
 
@@ -277,10 +618,10 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("identifier not found: " + node.Value)
 }
 
-func evalExpressions(expressions []ast.Expression, env *object.Environment) []object.Object {
+func (self *Evaluator) evalExpressions(expressions []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 	for _, expression := range expressions {
-		evaluated := Eval(expression, env)
+		evaluated := self.Eval(expression, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -290,14 +631,27 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (self *Evaluator) applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if self.depth + 1 >= MaxFrames {
+			return newError("stack overflow: more than %d nested calls", MaxFrames)
+		}
+
+		self.depth++
+		defer func() { self.depth-- }()
+
 		extended := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extended)
+		evaluated := self.Eval(fn.Body, extended)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
 	default:
 		return newError("not a function: %s", fn.Type()) 
 	}
@@ -316,20 +670,40 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
 	}
+	if obj == nil {
+		// the body was empty or ended in a statement
+		return NULL
+	}
 	return obj
 }
 
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 
+
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
@@ -350,11 +724,11 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+func (self *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, keyNode := range node.Keys() {
+		key := self.Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
@@ -364,7 +738,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := self.Eval(node.Pairs[keyNode], env)
 		if isError(value) { return value }
 
 		hashed := hashKey.HashKey()
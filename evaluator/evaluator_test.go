@@ -4,6 +4,7 @@ import (
 	"bear/lexer"
 	"bear/object"
 	"bear/parser"
+	"math"
 	"testing"
 )
 
@@ -27,6 +28,12 @@ func TestEvalIntegerExpression(t *testing.T) {
         {"3 * 3 * 3 + 10", 37},
         {"3 * (3 * 3) + 10", 37},
         {"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 ** 0", 1},
 	}
 
 	for _, test := range tests {
@@ -59,6 +66,18 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 >= 1", true},
+		{`"a" < "b"`, true},
+		{`"abc" > "abd"`, false},
+		{`"a" <= "a"`, true},
+		{`"b" >= "a"`, true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
 	}
 
 	for _, test := range tests {
@@ -183,6 +202,18 @@ func TestErrorHandling(t *testing.T) {
         	`{"name": "Monkey"}[fn(x) { x }];`,
         	"unusable as hash key: FUNCTION",
         },
+        {
+        	"fn(a, b) { a + b }(1)",
+        	"wrong number of arguments: want=2, got=1",
+        },
+        {
+        	"fn() { 1 }(1)",
+        	"wrong number of arguments: want=0, got=1",
+        },
+        {
+        	"let f = fn() { f() }; f();",
+        	"stack overflow: more than 1024 nested calls",
+        },
     }
 
     for _, test := range tests {
@@ -233,7 +264,7 @@ func TestFunctionalObject(t *testing.T) {
 		t.Fatalf("parameter is not 'x', got=%q", fn.Parameters[0])
 	}
 
-	expected := "(x + 2)"
+	expected := "{ (x + 2) }"
 
 	if fn.Body.String() != expected {
 		t.Fatalf("body is not %q. got=%q", expected, fn.Body.String())
@@ -258,6 +289,18 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestFunctionsWithoutValue(t *testing.T) {
+	tests := []string{
+		"fn() { }()",
+		"fn() { let x = 1; }()",
+		"let f = fn() { }; let g = fn() { f() }; g()",
+	}
+
+	for _, input := range tests {
+		testNullObject(t, testEval(input))
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World"`
 
@@ -490,4 +533,291 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 		return false 
 	}
 	return true
-}
\ No newline at end of file
+}
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input 			string
+		expectedInspect string
+	}{
+		{"5 + true;", "ERROR: 1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"let x = 1;\nfoobar", "ERROR: 2:1: identifier not found: foobar"},
+		{"let f = fn() {\n  -true\n};\nf()", "ERROR: 2:3: unknown operator: -BOOLEAN"},
+		{`len(1)`, "ERROR: 1:4: argument to `len` not supported, got INTEGER"},
+		{"for (x in 5) { x }", "ERROR: 1:11: cannot iterate over INTEGER"},
+		{"let f = fn() { g() };\nf();\nlet g = fn() { 1 };", "ERROR: 1:16: uninitialized variable"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		errObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObject.Inspect() != test.expectedInspect {
+			t.Errorf("wrong error. expected=%q, got=%q", test.expectedInspect, errObject.Inspect())
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input 		string
+		expected 	interface{}
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{`int("42")`, 42},
+		{"float(3)", 3.0},
+		{`float("2.5")`, 2.5},
+		{"float(3) / 2", 1.5},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		switch expected := test.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	tests := []struct {
+		input 			string
+		expectedMessage string
+	}{
+		{`int("abc")`, `could not convert "abc" to INTEGER`},
+		{`float(true)`, "argument to `float` not supported, got BOOLEAN"},
+		{`int(1, 2)`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		errObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObject.Message != test.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", test.expectedMessage, errObject.Message)
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input 		string
+		expected 	interface{}
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; }; i", 5},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{`let s = ""; for (c in "abc") { let s = c + s; }; s`, "cba"},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; }; s`, "abc"},
+		{"let i = 0; while (true) { if (i == 3) { break; } let i = i + 1; }; i", 3},
+		{"let odd = 0; for (x in [1, 2, 3, 4, 5]) { if (x - (x / 2) * 2 == 0) { continue; } let odd = odd + x; }; odd", 9},
+		{"let n = 0; for (a in [1, 2, 3]) { for (b in [1, 2, 3]) { if (b == 2) { break; } let n = n + 1; } }; n", 3},
+		{"let find = fn(xs, y) { for (x in xs) { if (x == y) { return true; } } false }; find([1, 2, 3], 2)", true},
+		{"let find = fn(xs, y) { for (x in xs) { if (x == y) { return true; } } false }; find([1, 2, 3], 4)", false},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		switch expected := test.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input 		string
+		expected 	interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { sum += x; }; sum", 10},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next(); next()", 3},
+		{"let mk = fn() { let c = 0; let inc = fn() { c += 1; c }; inc(); inc(); c }; mk()", 2},
+		{"let x = 1; let f = fn() { x = 10; 1 }; x += f(); x", 2},
+		{"y = 1", "ERROR: 1:3: assignment to undeclared variable y"},
+		{"len = 1", "ERROR: 1:5: cannot assign to builtin len"},
+		{`let x = 1; x += "a"`, "ERROR: 1:14: type mismatch: INTEGER + STRING"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		switch expected := test.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObject, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObject.Inspect() != expected {
+				t.Errorf("wrong error. expected=%q, got=%q", expected, errObject.Inspect())
+			}
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input 		string
+		expected 	interface{}
+	}{
+		{"true && false", false},
+		{"false || true", true},
+		{"1 && 2", true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"false && undefined", false},
+		{"true || undefined", true},
+		{"true && undefined", "ERROR: 1:9: identifier not found: undefined"},
+		{"let x = 0; let bump = fn() { x += 1; true }; false && bump(); true || bump(); x", 0},
+		{"let x = 0; let bump = fn() { x += 1; true }; true && bump(); false || bump(); x", 2},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		switch expected := test.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObject, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObject.Inspect() != expected {
+				t.Errorf("wrong error. expected=%q, got=%q", expected, errObject.Inspect())
+			}
+		}
+	}
+}
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []struct {
+		input 		string
+		expected 	interface{}
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a[0] + a[1] + a[2]", 9},
+		{"let a = [1, 2, 3]; a[2] *= 10; a[2]", 30},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": 1}; h["a"] += 1; h["a"]`, 2},
+		{"let grid = [[0, 0], [0, 0]]; grid[1][0] = 7; grid[1][0]", 7},
+		{"let a = [1, 2]; let b = a; b[0] = 3; a[0]", 3},
+		{"let a = [1, 2]; a[2] = 3", "ERROR: 1:22: array index out of range: 2 (length 2)"},
+		{`let a = [1, 2]; a["x"] = 3`, "ERROR: 1:24: array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn() {}] = 1", "ERROR: 1:24: unusable as hash key: FUNCTION"},
+		{`let s = "ab"; s[0] = "c"`, "ERROR: 1:20: index assignment not supported: STRING"},
+	}
+
+	for _, test := range tests {
+		evaluated := testEval(test.input)
+
+		switch expected := test.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObject, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObject.Inspect() != expected {
+				t.Errorf("wrong error. expected=%q, got=%q", expected, errObject.Inspect())
+			}
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct{
+		input 			string
+		checkOverflow 	bool
+		expected 		string
+	}{
+		{"1 / 0", false, "ERROR: 1:3: division by zero"},
+		{"let x = 5; x % (2 - 2)", false, "ERROR: 1:14: modulo by zero"},
+		{"let x = 1; x /= 0", false, "ERROR: 1:14: division by zero"},
+		{"9223372036854775807 + 1", true, "ERROR: 1:21: integer overflow: 9223372036854775807 + 1"},
+		{"4294967296 * 4294967296", true, "ERROR: 1:12: integer overflow: 4294967296 * 4294967296"},
+		{"2 ** 64", true, "ERROR: 1:3: integer overflow: 2 ** 64"},
+	}
+
+	for _, test := range tests {
+		evaluator := New()
+		evaluator.CheckOverflow = test.checkOverflow
+		evaluated := evaluator.Eval(parser.New(lexer.New(test.input)).ParseProgram(), object.NewEnvironment())
+
+		errObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", test.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObject.Inspect() != test.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", test.expected, errObject.Inspect())
+		}
+	}
+}
+
+func TestUncheckedOverflowWraps(t *testing.T) {
+	evaluated := testEval("9223372036854775807 + 1")
+	testIntegerObject(t, evaluated, math.MinInt64)
+}
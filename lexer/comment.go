@@ -0,0 +1,113 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+
+	"bear/token"
+)
+
+// commentGroup collects consecutive comment lines so the group directly
+// above a let statement can be attached to the let token as its doc.
+type commentGroup struct {
+	lines 		[]string
+	startLine 	int
+	endLine 	int
+}
+
+func (self *Lexer) skipWhiteSpaceAndComments() {
+	for {
+		self.skipWhiteSpace()
+
+		if self.ch != '/' {
+			return
+		}
+
+		switch self.peekChar() {
+		case '/':
+			line := self.line
+			text := self.readLineComment()
+			self.addComment(line, line, text)
+		case '*':
+			start := self.currentPosition()
+			text, ok := self.readBlockComment()
+			if !ok {
+				self.errors = append(self.errors, fmt.Sprintf("%s: unterminated block comment", start))
+				return
+			}
+			self.addComment(start.Line, self.line, text)
+		default:
+			return
+		}
+	}
+}
+
+// readLineComment reads a // comment up to the end of the line and
+// returns its text without the leading slashes.
+func (self *Lexer) readLineComment() string {
+	position := self.position + 2
+	for self.ch != '\n' && self.ch != 0 {
+		self.readChar()
+	}
+
+	text := strings.TrimRight(self.input[position:self.position], "\r")
+	return strings.TrimPrefix(text, " ")
+}
+
+// readBlockComment reads a possibly nested /* */ comment and returns its
+// text without the delimiters. It reports false if the input ends
+// before the comment is closed.
+func (self *Lexer) readBlockComment() (string, bool) {
+	position := self.position + 2
+	depth := 0
+
+	for self.ch != 0 {
+		if self.ch == '/' && self.peekChar() == '*' {
+			depth++
+			self.readChar()
+		} else if self.ch == '*' && self.peekChar() == '/' {
+			depth--
+			self.readChar()
+			if depth == 0 {
+				text := self.input[position:self.position - 1]
+				self.readChar()
+				return strings.TrimSpace(text), true
+			}
+		}
+		self.readChar()
+	}
+
+	return "", false
+}
+
+func (self *Lexer) addComment(startLine int, endLine int, text string) {
+	// a comment trailing code on the same line documents nothing
+	if startLine == self.lastTokenLine {
+		self.comments = commentGroup{}
+		return
+	}
+
+	if len(self.comments.lines) == 0 || startLine != self.comments.endLine + 1 {
+		self.comments = commentGroup{startLine: startLine}
+	}
+
+	self.comments.lines = append(self.comments.lines, text)
+	self.comments.endLine = endLine
+}
+
+// takeDoc returns the comment group ending on the line directly above
+// tok if tok is a let statement, and starts a new group.
+func (self *Lexer) takeDoc(tok token.Token) string {
+	comments := self.comments
+	self.comments = commentGroup{}
+
+	if tok.Type != token.LET || len(comments.lines) == 0 {
+		return ""
+	}
+
+	if comments.endLine != tok.Pos.Line - 1 {
+		return ""
+	}
+
+	return strings.Join(comments.lines, "\n")
+}
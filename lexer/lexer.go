@@ -4,19 +4,37 @@ import "bear/token"
 
 type Lexer struct {
 	input			string
+	filename 		string
 	position 		int // 	current position in input (current char)
 	readPosition 	int // 	current reading position (after current char)
 	ch 				byte // current char under examination
+	line 			int // 	line of the current char
+	column 			int // 	column of the current char
+
+	lastTokenLine 	int // 	line the previous token ended on
+	comments 		commentGroup
+	errors 			[]string
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions refer to filename.
+func NewFile(filename string, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
 // read next position
 func (self *Lexer) readChar() {
+	if self.ch == '\n' {
+		self.line++
+		self.column = 0
+	}
+
 	if self.readPosition >= len(self.input) {
 		self.ch = 0
 	} else {
@@ -24,12 +42,40 @@ func (self *Lexer) readChar() {
 	}
 	self.position = self.readPosition
 	self.readPosition += 1
+	self.column += 1
+}
+
+func (self *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: 	self.filename,
+		Offset: 	self.position,
+		Line: 		self.line,
+		Column: 	self.column,
+	}
 }
 
 func (self *Lexer) NextToken() token.Token {
-	var tok token.Token
+	self.skipWhiteSpaceAndComments()
+
+	start := self.currentPosition()
+	tok := self.readToken()
+	tok.Pos = start
+	tok.End = self.currentPosition()
+	tok.Doc = self.takeDoc(tok)
+
+	self.lastTokenLine = tok.End.Line
 
-	self.skipWhiteSpace()
+	return tok
+}
+
+// Errors returns the errors found while reading tokens, such as
+// unterminated comments.
+func (self *Lexer) Errors() []string {
+	return self.errors
+}
+
+func (self *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch self.ch {
 	case '=':
@@ -42,9 +88,9 @@ func (self *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, self.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, self.ch)
+		tok = self.readOperator(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, self.ch)
+		tok = self.readOperator(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if self.peekChar() == '=' {
 			ch := self.ch
@@ -55,13 +101,23 @@ func (self *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, self.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, self.ch)
+		tok = self.readOperator(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, self.ch)
+		if self.peekChar() == '*' {
+			tok = self.readDouble(token.POWER)
+		} else {
+			tok = self.readOperator(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, self.ch)
+	case '&':
+		tok = self.readDouble(token.AND)
+	case '|':
+		tok = self.readDouble(token.OR)
 	case '<':
-		tok = newToken(token.LT, self.ch)
+		tok = self.readOperator(token.LT, token.LT_EQ)
 	case '>':
-		tok = newToken(token.GT, self.ch)
+		tok = self.readOperator(token.GT, token.GT_EQ)
 	case ';':
 		tok = newToken(token.SEMICOLON, self.ch)
 	case '(':
@@ -92,8 +148,7 @@ func (self *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(self.ch) {
-			tok.Type = token.INT
-			tok.Literal = self.readNumber()
+			tok.Literal, tok.Type = self.readNumber()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, self.ch)
@@ -104,6 +159,30 @@ func (self *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// readOperator reads an operator that has a form followed by =, such as
+// + and += or < and <=.
+func (self *Lexer) readOperator(single token.TokenType, withEquals token.TokenType) token.Token {
+	if self.peekChar() != '=' {
+		return newToken(single, self.ch)
+	}
+
+	ch := self.ch
+	self.readChar()
+	return token.Token{Type: withEquals, Literal: string(ch) + string(self.ch)}
+}
+
+// readDouble reads an operator written as the same character twice, such
+// as &&. A single character on its own is illegal.
+func (self *Lexer) readDouble(double token.TokenType) token.Token {
+	if self.peekChar() != self.ch {
+		return newToken(token.ILLEGAL, self.ch)
+	}
+
+	ch := self.ch
+	self.readChar()
+	return token.Token{Type: double, Literal: string(ch) + string(self.ch)}
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
@@ -124,18 +203,39 @@ func (self *Lexer) readIdentifier() string {
 	return self.input[position:self.position]
 }
 
+// skipShebang skips a #! line at the very start of the input, so scripts
+// can be made executable.
+func (self *Lexer) skipShebang() {
+	if self.ch != '#' || self.peekChar() != '!' {
+		return
+	}
+
+	for self.ch != '\n' && self.ch != 0 {
+		self.readChar()
+	}
+}
+
 func (self *Lexer) skipWhiteSpace() {
 	for self.ch == ' ' || self.ch == '\t' || self.ch == '\n' || self.ch == '\r' {
 		self.readChar()
 	}
 }
 
-func (self *Lexer) readNumber() string {
+func (self *Lexer) readNumber() (string, token.TokenType) {
 	position := self.position
 	for isDigit(self.ch) {
 		self.readChar()
 	}
-	return self.input[position:self.position]
+
+	if self.ch != '.' || !isDigit(self.peekChar()) {
+		return self.input[position:self.position], token.INT
+	}
+
+	self.readChar()
+	for isDigit(self.ch) {
+		self.readChar()
+	}
+	return self.input[position:self.position], token.FLOAT
 }
 
 func (self *Lexer) peekChar() byte {
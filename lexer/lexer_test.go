@@ -51,7 +51,7 @@ func TestFunctionalToken(t *testing.T) {
 	};
 
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 
 	if (5 < 10) {
@@ -203,4 +203,272 @@ func TestMoreToken(t *testing.T) {
 			 i, test.expectedLiteral, tok.Literal)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "ab";`
+
+	tests := []struct {
+		expectedType 	token.TokenType
+		expectedPos 	token.Position
+		expectedEnd 	token.Position
+	}{
+		{token.LET, 		token.Position{Filename: "a.bear", Offset: 0, Line: 1, Column: 1}, 	 token.Position{Filename: "a.bear", Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, 		token.Position{Filename: "a.bear", Offset: 4, Line: 1, Column: 5}, 	 token.Position{Filename: "a.bear", Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, 		token.Position{Filename: "a.bear", Offset: 6, Line: 1, Column: 7}, 	 token.Position{Filename: "a.bear", Offset: 7, Line: 1, Column: 8}},
+		{token.INT, 		token.Position{Filename: "a.bear", Offset: 8, Line: 1, Column: 9}, 	 token.Position{Filename: "a.bear", Offset: 9, Line: 1, Column: 10}},
+		{token.SEMICOLON, 	token.Position{Filename: "a.bear", Offset: 9, Line: 1, Column: 10},  token.Position{Filename: "a.bear", Offset: 10, Line: 1, Column: 11}},
+		{token.IDENT, 		token.Position{Filename: "a.bear", Offset: 13, Line: 2, Column: 3},  token.Position{Filename: "a.bear", Offset: 14, Line: 2, Column: 4}},
+		{token.PLUS, 		token.Position{Filename: "a.bear", Offset: 15, Line: 2, Column: 5},  token.Position{Filename: "a.bear", Offset: 16, Line: 2, Column: 6}},
+		{token.STRING, 		token.Position{Filename: "a.bear", Offset: 17, Line: 2, Column: 7},  token.Position{Filename: "a.bear", Offset: 21, Line: 2, Column: 11}},
+		{token.SEMICOLON, 	token.Position{Filename: "a.bear", Offset: 21, Line: 2, Column: 11}, token.Position{Filename: "a.bear", Offset: 22, Line: 2, Column: 12}},
+		{token.EOF, 		token.Position{Filename: "a.bear", Offset: 22, Line: 2, Column: 12}, token.Position{Filename: "a.bear", Offset: 23, Line: 2, Column: 13}},
+	}
+
+	l := NewFile("a.bear", input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+			 i, test.expectedType, tok.Type)
+		}
+
+		if tok.Pos != test.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v",
+			 i, test.expectedPos, tok.Pos)
+		}
+
+		if tok.End != test.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v",
+			 i, test.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestFloatToken(t *testing.T) {
+	input := `3.14 10 0.5 7.foo`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, 	"3.14"},
+		{token.INT, 	"10"},
+		{token.FLOAT, 	"0.5"},
+		{token.INT, 	"7"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, 	"foo"},
+		{token.EOF, 	""},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+			 i, test.expectedType, tok.Type)
+		}
+
+		if tok.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+			 i, test.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing comment
+/* block
+   comment */ x /* nested /* inner */ still comment */ + 1;
+// last comment`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, 		"let"},
+		{token.IDENT, 		"x"},
+		{token.ASSIGN, 		"="},
+		{token.INT, 		"5"},
+		{token.SEMICOLON, 	";"},
+		{token.IDENT, 		"x"},
+		{token.PLUS, 		"+"},
+		{token.INT, 		"1"},
+		{token.SEMICOLON, 	";"},
+		{token.EOF, 		""},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+			 i, test.expectedType, tok.Type)
+		}
+
+		if tok.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+			 i, test.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("lexer has errors: %v", l.Errors())
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("let x = 5;\n  /* open /* nested */")
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. got=%d (%v)", len(errors), errors)
+	}
+
+	expected := "2:3: unterminated block comment"
+	if errors[0] != expected {
+		t.Fatalf("wrong error. expected=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `// add returns the sum
+// of a and b.
+let add = fn(a, b) { a + b };
+
+// detached
+
+let one = 1; // trailing
+let two = 2;
+/* block doc */
+let three = 3;
+// not on a let
+three;`
+
+	expected := []string{"add returns the sum\nof a and b.", "", "", "block doc"}
+
+	l := New(input)
+
+	docs := []string{}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.LET {
+			docs = append(docs, tok.Doc)
+		} else if tok.Doc != "" {
+			t.Errorf("doc attached to %q token: %q", tok.Literal, tok.Doc)
+		}
+	}
+
+	if len(docs) != len(expected) {
+		t.Fatalf("wrong number of let tokens. got=%d", len(docs))
+	}
+
+	for i, doc := range expected {
+		if docs[i] != doc {
+			t.Errorf("docs[%d] wrong. expected=%q, got=%q", i, doc, docs[i])
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5;`
+
+	expected := []token.TokenType{
+		token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.PLUS_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.MINUS_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.ASTERISK_ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH_ASSIGN, token.INT, token.SEMICOLON,
+		token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	input := `a && b || c & d | e`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, 	"a"},
+		{token.AND, 	"&&"},
+		{token.IDENT, 	"b"},
+		{token.OR, 		"||"},
+		{token.IDENT, 	"c"},
+		{token.ILLEGAL, "&"},
+		{token.IDENT, 	"d"},
+		{token.ILLEGAL, "|"},
+		{token.IDENT, 	"e"},
+		{token.EOF, 	""},
+	}
+
+	l := New(input)
+
+	for i, test := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != test.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+			 i, test.expectedType, tok.Type)
+		}
+
+		if tok.Literal != test.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+			 i, test.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndArithmeticOperators(t *testing.T) {
+	input := `a <= b >= c < d > e % f ** g * h`
+
+	expected := []token.TokenType{
+		token.IDENT, token.LT_EQ, token.IDENT, token.GT_EQ, token.IDENT,
+		token.LT, token.IDENT, token.GT, token.IDENT, token.PERCENT,
+		token.IDENT, token.POWER, token.IDENT, token.ASTERISK, token.IDENT,
+		token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+func TestShebangLine(t *testing.T) {
+	l := New("#!/usr/bin/env bear\nlet x = 1;")
+
+	tok := l.NextToken()
+	if tok.Type != token.LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.LET, tok.Type)
+	}
+
+	expected := token.Position{Offset: 20, Line: 2, Column: 1}
+	if tok.Pos != expected {
+		t.Fatalf("pos wrong. expected=%+v, got=%+v", expected, tok.Pos)
+	}
+}
@@ -1,28 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"os"
-	"os/user"
-	"bear/repl"
+	"bear/cli"
 )
 
-const BEAR_TEXT = `
-██████╗ ███████╗ █████╗ ██████╗ 
-██╔══██╗██╔════╝██╔══██╗██╔══██╗
-██████╔╝█████╗  ███████║██████╔╝
-██╔══██╗██╔══╝  ██╔══██║██╔══██╗
-██████╔╝███████╗██║  ██║██║  ██║
-╚═════╝ ╚══════╝╚═╝  ╚═╝╚═╝  ╚═╝
-`
-
 func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
-	}
-	fmt.Printf(BEAR_TEXT)
-	fmt.Printf("Welcome to the Bear Programming language, %s", user.Username)
-	fmt.Printf("\nType in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
-}
\ No newline at end of file
+	os.Exit(cli.Main(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,74 @@
+package object
+
+import "math"
+
+// AddInt returns a + b and whether the sum overflowed int64.
+func AddInt(a, b int64) (int64, bool) {
+	result := a + b
+	overflow := (a >= 0) == (b >= 0) && (result >= 0) != (a >= 0)
+	return result, overflow
+}
+
+// SubInt returns a - b and whether the difference overflowed int64.
+func SubInt(a, b int64) (int64, bool) {
+	result := a - b
+	overflow := (a >= 0) != (b >= 0) && (result >= 0) != (a >= 0)
+	return result, overflow
+}
+
+// MulInt returns a * b and whether the product overflowed int64.
+func MulInt(a, b int64) (int64, bool) {
+	result := a * b
+	if a == 0 || b == 0 {
+		return result, false
+	}
+
+	overflow := result / b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64)
+	return result, overflow
+}
+
+// DivInt returns a / b and whether the quotient overflowed int64, which
+// only happens for the smallest int64 divided by -1. b must not be zero.
+func DivInt(a, b int64) (int64, bool) {
+	return a / b, a == math.MinInt64 && b == -1
+}
+
+// PowInt raises base to a non-negative exp by repeated squaring and
+// reports whether the result overflowed int64.
+func PowInt(base, exp int64) (int64, bool) {
+	result := int64(1)
+	overflow := false
+
+	for exp > 0 {
+		var o bool
+		if exp & 1 == 1 {
+			result, o = MulInt(result, base)
+			overflow = overflow || o
+		}
+
+		exp >>= 1
+		if exp > 0 {
+			base, o = MulInt(base, base)
+			overflow = overflow || o
+		}
+	}
+
+	return result, overflow
+}
+
+// IsNumber reports whether obj is an INTEGER or a FLOAT, which arithmetic
+// and comparisons may mix.
+func IsNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+// ToFloat widens an INTEGER or FLOAT object to a float64.
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *Float:
+		return obj.Value
+	}
+	return 0
+}
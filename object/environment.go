@@ -28,3 +28,26 @@ func (self *Environment) Set(name string, value Object) Object {
 	self.store[name] = value
 	return value
 }
+
+// Declare binds name to value unless this environment already has a
+// binding for it.
+func (self *Environment) Declare(name string, value Object) {
+	if _, ok := self.store[name]; !ok {
+		self.store[name] = value
+	}
+}
+
+// Assign updates the innermost binding of name and reports whether name
+// was bound at all.
+func (self *Environment) Assign(name string, value Object) bool {
+	if _, ok := self.store[name]; ok {
+		self.store[name] = value
+		return true
+	}
+
+	if self.outer != nil {
+		return self.outer.Assign(name, value)
+	}
+
+	return false
+}
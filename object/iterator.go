@@ -0,0 +1,77 @@
+package object
+
+import "sort"
+
+// Iterator walks the elements a for loop visits. The VM keeps one on the
+// stack for the duration of a loop.
+type Iterator struct {
+	Elements 	[]Object
+	index 		int
+}
+
+func (self *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (self *Iterator) Inspect() string { return "iterator" }
+
+// Next returns the next element, or false once all elements are visited.
+func (self *Iterator) Next() (Object, bool) {
+	if self.index >= len(self.Elements) {
+		return nil, false
+	}
+
+	element := self.Elements[self.index]
+	self.index++
+	return element, true
+}
+
+// Iterate returns the elements a for loop over obj visits: the elements of
+// an array, the characters of a string or the keys of a hash. Hash keys
+// are sorted so both engines visit them in the same order.
+func Iterate(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Elements, true
+	case *String:
+		elements := []Object{}
+		for _, ch := range obj.Value {
+			elements = append(elements, &String{Value: string(ch)})
+		}
+		return elements, true
+	case *Hash:
+		return obj.Keys(), true
+	default:
+		return nil, false
+	}
+}
+
+// Keys returns the keys of the hash, ordered by type and then by value.
+func (self *Hash) Keys() []Object {
+	keys := []Object{}
+	for _, pair := range self.Pairs {
+		keys = append(keys, pair.Key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+
+	return keys
+}
+
+func lessKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	default:
+		return false
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"bytes"
 	"bear/ast"
 	"bear/code"
+	"bear/token"
 	"strings"
+	"strconv"
+	"math"
 	"hash/fnv"
 )
 
 const (
 	INTEGER_OBJ 		  = "INTEGER"
+	FLOAT_OBJ 			  = "FLOAT"
 	BOOLEAN_OBJ 		  = "BOOLEAN"
 	NULL_OBJ 			  = "NULL"
 	RETURN_VALUE_OBJ 	  = "RETURN_VALUE"
@@ -21,6 +25,11 @@ const (
 	ARRAY_OBJ 			  = "ARRAY"
 	HASH_OBJ 			  = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	CLOSURE_OBJ 		  = "CLOSURE"
+	CELL_OBJ 			  = "CELL"
+	ITERATOR_OBJ 		  = "ITERATOR"
+	BREAK_OBJ 			  = "BREAK"
+	CONTINUE_OBJ 		  = "CONTINUE"
 )
 
 type ObjectType string
@@ -37,6 +46,19 @@ type Integer struct {
 func (self *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (self *Integer) Inspect() string { return fmt.Sprintf("%d", self.Value) }
 
+type Float struct {
+	Value float64
+}
+
+func (self *Float) Type() ObjectType { return FLOAT_OBJ }
+func (self *Float) Inspect() string {
+	out := strconv.FormatFloat(self.Value, 'g', -1, 64)
+	if strings.ContainsAny(out, ".eIN") {
+		return out
+	}
+	return out + ".0"
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -56,39 +78,58 @@ type ReturnValue struct {
 func (self *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (self *ReturnValue) Inspect() string { return self.Value.Inspect() }
 
+// Break and Continue unwind the evaluator out of a loop body, the way
+// ReturnValue unwinds it out of a function body.
+type Break struct {}
+
+func (self *Break) Type() ObjectType { return BREAK_OBJ }
+func (self *Break) Inspect() string { return "break" }
+
+type Continue struct {}
+
+func (self *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (self *Continue) Inspect() string { return "continue" }
+
 
 type Error struct {
 	Message string
+	Pos 	token.Position // where the error was raised, if known
 }
 
 func (self *Error) Type() ObjectType { return ERROR_OBJ }
-func (self *Error) Inspect() string { return "ERROR: " + self.Message  }
+func (self *Error) Inspect() string {
+	if self.Pos.IsValid() {
+		return "ERROR: " + self.Pos.String() + ": " + self.Message
+	}
+	return "ERROR: " + self.Message
+}
+
+// Error formats the error like a runtime error reported by the VM.
+func (self *Error) Error() string {
+	if self.Pos.IsValid() {
+		return self.Pos.String() + ": " + self.Message
+	}
+	return self.Message
+}
 
 
 type Function struct {
 	Parameters 	[]*ast.Identifier
 	Body 		*ast.BlockStatement
 	Env 		*Environment
+	Name 		string
 }
 
 func (self *Function) Type() ObjectType { return FUNCTION_OBJ }
-func (self *Function) Inspect() string { 
-	var out bytes.Buffer
-
-	params := []string{}
+func (self *Function) Inspect() string { return inspectFunction(self.Name) }
 
-	for _, p := range self.Parameters {
-		params = append(params, p.String())
+// inspectFunction is how a function prints on either engine. The VM does
+// not keep the source of functions, so only the name is shown.
+func inspectFunction(name string) string {
+	if name == "" {
+		return "<fn>"
 	}
-
-	out.WriteString("fn")
-	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") {\n")
-	out.WriteString(self.Body.String())
-	out.WriteString("\n}")
-
-	return out.String()
+	return "<fn " + name + ">"
 }
 
 type String struct {
@@ -148,6 +189,15 @@ func (self *Integer) HashKey() HashKey {
 	return HashKey{Type: self.Type(), Value: uint64(self.Value)}
 }
 
+// HashKey of an integral float is that of the equal integer, as 1 == 1.0
+// makes them the same key.
+func (self *Float) HashKey() HashKey {
+	if self.Value == math.Trunc(self.Value) && self.Value >= math.MinInt64 && self.Value < math.MaxInt64 {
+		return (&Integer{Value: int64(self.Value)}).HashKey()
+	}
+	return HashKey{Type: self.Type(), Value: math.Float64bits(self.Value)}
+}
+
 func (self *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(self.Value))
@@ -167,8 +217,10 @@ func (self *Hash) Type() ObjectType { return HASH_OBJ }
 func (self *Hash) Inspect() string {
 	var out bytes.Buffer
 
+	// sorted so that printing a hash is deterministic
 	pairs := []string{}
-	for _, pair := range self.Pairs {
+	for _, key := range self.Keys() {
+		pair := self.Pairs[key.(Hashable).HashKey()]
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -183,11 +235,34 @@ type Hashable interface {
 }
 
 type CompiledFunction struct {
-	Instructions code.Instructions
-	NumLocals 	 int
+	Instructions 	code.Instructions
+	NumLocals 	 	int
+	NumParameters 	int
+	SourceMap 		code.SourceMap
+	Name 			string
 }
 
 func (self *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 func (self *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", self)
-}
\ No newline at end of file
+}
+
+type Closure struct {
+	Fn 		*CompiledFunction
+	Free 	[]Object
+}
+
+func (self *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (self *Closure) Inspect() string { return inspectFunction(self.Fn.Name) }
+
+// Cell holds a variable captured by a closure. The frame that declared
+// the variable and every closure capturing it share the cell, so an
+// assignment through any of them is seen by all.
+type Cell struct {
+	Value Object
+}
+
+func (self *Cell) Type() ObjectType { return CELL_OBJ }
+func (self *Cell) Inspect() string {
+	return fmt.Sprintf("Cell[%p]", self)
+}
@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"} 
@@ -19,4 +22,107 @@ func TestStringHashKey(t *testing.T) {
 	if hello1.HashKey() == diff1.HashKey() {
 		t.Errorf("strings with different content have same hash keys")
 	}
-}
\ No newline at end of file
+}
+func TestFloatHashKey(t *testing.T) {
+	tests := []struct{
+		float 		float64
+		integer 	int64
+		same 		bool
+	}{
+		{1, 1, true},
+		{-3, -3, true},
+		{0, 0, true},
+		{math.Copysign(0, -1), 0, true},
+		{1.5, 1, false},
+		{math.Inf(1), math.MaxInt64, false},
+		{math.NaN(), 0, false},
+	}
+
+	for _, test := range tests {
+		float := &Float{Value: test.float}
+		integer := &Integer{Value: test.integer}
+
+		if same := float.HashKey() == integer.HashKey(); same != test.same {
+			t.Errorf("hash keys of %g and %d the same: want=%t, got=%t", test.float, test.integer, test.same, same)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct{
+		value 		float64
+		expected 	string
+	}{
+		{3.5, "3.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1e+21"},
+	}
+
+	for _, test := range tests {
+		float := &Float{Value: test.value}
+		if float.Inspect() != test.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", test.value, test.expected, float.Inspect())
+		}
+	}
+}
+
+func TestCheckedIntArithmetic(t *testing.T) {
+	tests := []struct{
+		name 		string
+		fn 			func(a, b int64) (int64, bool)
+		a, b 		int64
+		expected 	int64
+		overflow 	bool
+	}{
+		{"add", AddInt, 1, 2, 3, false},
+		{"add", AddInt, math.MaxInt64, 1, math.MinInt64, true},
+		{"add", AddInt, math.MinInt64, -1, math.MaxInt64, true},
+		{"add", AddInt, math.MaxInt64, math.MinInt64, -1, false},
+		{"sub", SubInt, math.MinInt64, 1, math.MaxInt64, true},
+		{"sub", SubInt, 0, math.MinInt64, math.MinInt64, true},
+		{"sub", SubInt, -1, math.MinInt64, math.MaxInt64, false},
+		{"mul", MulInt, 1 << 31, 1 << 31, 1 << 62, false},
+		{"mul", MulInt, 1 << 32, 1 << 32, 0, true},
+		{"mul", MulInt, -1, math.MinInt64, math.MinInt64, true},
+		{"mul", MulInt, math.MinInt64, -1, math.MinInt64, true},
+		{"mul", MulInt, 0, math.MinInt64, 0, false},
+		{"div", DivInt, math.MinInt64, -1, math.MinInt64, true},
+		{"div", DivInt, 7, -2, -3, false},
+		{"pow", PowInt, 2, 62, 1 << 62, false},
+		{"pow", PowInt, 2, 63, math.MinInt64, true},
+		{"pow", PowInt, -2, 63, math.MinInt64, false},
+		{"pow", PowInt, 3, 0, 1, false},
+	}
+
+	for _, test := range tests {
+		result, overflow := test.fn(test.a, test.b)
+
+		if result != test.expected || overflow != test.overflow {
+			t.Errorf("%s(%d, %d) wrong. want=(%d, %t), got=(%d, %t)",
+				test.name, test.a, test.b, test.expected, test.overflow, result, overflow)
+		}
+	}
+}
+
+func TestNumberPromotion(t *testing.T) {
+	tests := []struct{
+		obj 		Object
+		isNumber 	bool
+		float 		float64
+	}{
+		{&Integer{Value: -3}, true, -3},
+		{&Float{Value: 2.5}, true, 2.5},
+		{&String{Value: "1"}, false, 0},
+		{&Boolean{Value: true}, false, 0},
+	}
+
+	for _, test := range tests {
+		if got := IsNumber(test.obj); got != test.isNumber {
+			t.Errorf("IsNumber(%s) = %t, want %t", test.obj.Inspect(), got, test.isNumber)
+		}
+		if got := ToFloat(test.obj); got != test.float {
+			t.Errorf("ToFloat(%s) = %g, want %g", test.obj.Inspect(), got, test.float)
+		}
+	}
+}
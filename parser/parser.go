@@ -11,24 +11,39 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN 			// = or +=
+	OR 				// ||
+	AND 			// &&
 	EQUALS			// ==
 	LESSGREATER		// > or <
 	SUM 			// +
 	PRODUCT 		// *
 	PREFIX 			// -X or !X
+	POWER 			// **, binds tighter than a prefix operator on its left
 	CALL 			// myFunction(X)
 	INDEX  			// array[index]
 )
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN: 			ASSIGN,
+	token.PLUS_ASSIGN: 		ASSIGN,
+	token.MINUS_ASSIGN: 	ASSIGN,
+	token.ASTERISK_ASSIGN: 	ASSIGN,
+	token.SLASH_ASSIGN: 	ASSIGN,
+	token.OR: 		OR,
+	token.AND: 		AND,
 	token.EQ: 		EQUALS,
 	token.NOT_EQ: 	EQUALS,
 	token.LT:		LESSGREATER,
 	token.GT:		LESSGREATER,
+	token.LT_EQ:	LESSGREATER,
+	token.GT_EQ:	LESSGREATER,
 	token.PLUS: 	SUM,
 	token.MINUS: 	SUM,
 	token.SLASH: 	PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT: 	PRODUCT,
+	token.POWER: 	POWER,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
@@ -46,6 +61,14 @@ type Parser struct {
 
 	prefixParseFns 	map[token.TokenType]prefixParseFn
 	infixParseFns	map[token.TokenType]infixParseFn
+
+	loopDepth 		int // number of loops enclosing the current token
+
+	// break and continue may end an if expression that is a whole
+	// statement, but not one whose value is used
+	valueDepth 		int // number of expressions enclosing the current token whose value is used
+	statement 		bool // whether the next expression is a whole statement
+	loopControls 	[]token.Token // break and continue statements of the current loop outside of any value
 }
 
 func New(self *lexer.Lexer) *Parser {
@@ -58,6 +81,7 @@ func New(self *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -78,13 +102,26 @@ func New(self *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression) 
 	p.registerInfix(token.LT, p.parseInfixExpression) 
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	return p
 }
 
 func (self *Parser) Errors() []string {
-	return self.errors
+	errors := []string{}
+	errors = append(errors, self.lex.Errors()...)
+	return append(errors, self.errors...)
 }
 
 func (self *Parser) nextToken() {
@@ -113,6 +150,14 @@ func (self *Parser) parseStatement() ast.Statement {
 		return self.parseLetStatement()
 	case token.RETURN:
 		return self.parseReturnStatement()
+	case token.WHILE:
+		return self.parseWhileStatement()
+	case token.FOR:
+		return self.parseForStatement()
+	case token.BREAK:
+		return self.parseBreakStatement()
+	case token.CONTINUE:
+		return self.parseContinueStatement()
 	default:
 		return self.parseExpressionStatement()
 	}
@@ -133,6 +178,10 @@ func (self *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = self.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if self.peekTokenIs(token.SEMICOLON) { self.nextToken() }
 
 	return stmt
@@ -150,9 +199,99 @@ func (self *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (self *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: self.curToken}
+
+	if !self.expectPeek(token.LPAREN) { return nil }
+
+	self.nextToken()
+
+	stmt.Condition = self.parseExpression(LOWEST)
+
+	if !self.expectPeek(token.RPAREN) { return nil }
+	if !self.expectPeek(token.LBRACE) { return nil }
+
+	stmt.Body = self.parseLoopBody()
+
+	if self.peekTokenIs(token.SEMICOLON) { self.nextToken() }
+
+	return stmt
+}
+
+func (self *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: self.curToken}
+
+	if !self.expectPeek(token.LPAREN) { return nil }
+	if !self.expectPeek(token.IDENT) { return nil }
+
+	stmt.Variable = &ast.Identifier{Token: self.curToken, Value: self.curToken.Literal}
+
+	if !self.expectPeek(token.IN) { return nil }
+
+	self.nextToken()
+
+	stmt.Iterable = self.parseExpression(LOWEST)
+
+	if !self.expectPeek(token.RPAREN) { return nil }
+	if !self.expectPeek(token.LBRACE) { return nil }
+
+	stmt.Body = self.parseLoopBody()
+
+	if self.peekTokenIs(token.SEMICOLON) { self.nextToken() }
+
+	return stmt
+}
+
+func (self *Parser) parseLoopBody() *ast.BlockStatement {
+	self.loopDepth++
+	valueDepth, loopControls := self.valueDepth, len(self.loopControls)
+	self.valueDepth = 0
+	defer func() {
+		self.loopDepth--
+		self.valueDepth = valueDepth
+		self.loopControls = self.loopControls[:loopControls]
+	}()
+
+	return self.parseBlockStatement()
+}
+
+// checkLoopControl reports a break or continue at tok that is not
+// directly inside a loop.
+func (self *Parser) checkLoopControl(tok token.Token) {
+	switch {
+	case self.loopDepth == 0:
+		self.errorf(tok.Pos, "%s outside loop", tok.Literal)
+	case self.valueDepth > 0:
+		self.errorf(tok.Pos, "%s inside an expression", tok.Literal)
+	default:
+		self.loopControls = append(self.loopControls, tok)
+	}
+}
+
+func (self *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: self.curToken}
+
+	self.checkLoopControl(stmt.Token)
+
+	if self.peekTokenIs(token.SEMICOLON) { self.nextToken() }
+
+	return stmt
+}
+
+func (self *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: self.curToken}
+
+	self.checkLoopControl(stmt.Token)
+
+	if self.peekTokenIs(token.SEMICOLON) { self.nextToken() }
+
+	return stmt
+}
+
 func (self *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: self.curToken}
 
+	self.statement = true
 	stmt.Expression = self.parseExpression(LOWEST)
 
 	if self.peekTokenIs(token.SEMICOLON) { self.nextToken() }
@@ -179,7 +318,11 @@ func (self *Parser) expectPeek(tt token.TokenType) bool {
 }
 
 func (self *Parser) peekError(tt token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", tt, self.peekToken.Type)
+	self.errorf(self.peekToken.Pos, "expected next token to be %s, got %s instead", tt, self.peekToken.Type)
+}
+
+func (self *Parser) errorf(pos token.Position, format string, a ...interface{}) {
+	msg := fmt.Sprintf("%s: %s", pos, fmt.Sprintf(format, a...))
 	self.errors = append(self.errors, msg)
 }
 
@@ -192,11 +335,19 @@ func (self *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (self *Parser) parseExpression(precedence int) ast.Expression {
+	statement := self.statement
+	self.statement = false
+	if !statement {
+		self.valueDepth++
+		defer func() { self.valueDepth-- }()
+	}
+
 	prefix := self.prefixParseFns[self.curToken.Type]
 	if prefix == nil { 
 		self.noPrefixParseFnError(self.curToken.Type)
 		return nil 
 	}
+	loopControls := len(self.loopControls)
 	leftExp := prefix()
 
 	for !self.peekTokenIs(token.SEMICOLON) && precedence < self.peekPrecedence() {
@@ -205,6 +356,12 @@ func (self *Parser) parseExpression(precedence int) ast.Expression {
 			return leftExp
 		}
 
+		// the statement turns out to use the value of its if expression
+		for _, tok := range self.loopControls[loopControls:] {
+			self.errorf(tok.Pos, "%s inside an expression", tok.Literal)
+		}
+		self.loopControls = self.loopControls[:loopControls]
+
 		self.nextToken()
 
 		leftExp = infix(leftExp)
@@ -221,8 +378,21 @@ func (self *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(self.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", self.curToken.Literal)
-		self.errors = append(self.errors, msg)
+		self.errorf(self.curToken.Pos, "could not parse %q as integer", self.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (self *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: self.curToken}
+
+	value, err := strconv.ParseFloat(self.curToken.Literal, 64)
+	if err != nil {
+		self.errorf(self.curToken.Pos, "could not parse %q as float", self.curToken.Literal)
 		return nil
 	}
 
@@ -232,8 +402,7 @@ func (self *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (self *Parser) noPrefixParseFnError(tokenType token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", tokenType)
-	self.errors = append(self.errors, msg)
+	self.errorf(self.curToken.Pos, "no prefix parse function for %s found", tokenType)
 }
 
 func (self *Parser) parsePrefixExpression() ast.Expression {
@@ -271,12 +440,44 @@ func (self *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := self.curPrecedence()
+
+	// ** is right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+	if expression.Operator == "**" {
+		precedence--
+	}
+
 	self.nextToken()
 	expression.Right = self.parseExpression(precedence)
 
 	return expression
 }
 
+func (self *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		// after an earlier error the target may be incomplete and cannot be
+		// printed; that error already explains the problem
+		if len(self.Errors()) == 0 {
+			self.errorf(self.curToken.Pos, "cannot assign to %s", left.String())
+		}
+		return nil
+	}
+
+	expression := &ast.AssignExpression{
+		Token: 		self.curToken,
+		Target: 	left,
+		Operator: 	self.curToken.Literal,
+	}
+
+	self.nextToken()
+
+	// assignment is right associative: a = b = c assigns c to b first
+	expression.Value = self.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (self *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: self.curToken, Value: self.curTokenIs(token.TRUE)}
 }
@@ -333,6 +534,8 @@ func (self *Parser) parseBlockStatement() *ast.BlockStatement {
 		self.nextToken()
 	}
 
+	block.Rbrace = self.curToken
+
 	return block
 }
 
@@ -345,7 +548,11 @@ func (self *Parser) parseFunctionLiteral() ast.Expression {
 
 	if !self.expectPeek(token.LBRACE) { return nil }
 
+	// a function body starts outside of any loop
+	loopDepth, valueDepth, loopControls := self.loopDepth, self.valueDepth, self.loopControls
+	self.loopDepth, self.valueDepth, self.loopControls = 0, 0, nil
 	literal.Body = self.parseBlockStatement()
+	self.loopDepth, self.valueDepth, self.loopControls = loopDepth, valueDepth, loopControls
 
 	return literal
 }
@@ -378,6 +585,7 @@ func (self *Parser) parseFunctionParameters() []*ast.Identifier {
 func (self *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	expression := &ast.CallExpression{Token: self.curToken, Function: function}
 	expression.Arguments = self.parseExpressionList(token.RPAREN)
+	expression.Rparen = self.curToken
 	return expression
 }
 
@@ -410,6 +618,7 @@ func (self *Parser) parseStringLiteral() ast.Expression {
 func (self *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: self.curToken}
 	array.Elements = self.parseExpressionList(token.RBRACKET)
+	array.Rbracket = self.curToken
 	return array
 }
 
@@ -447,6 +656,8 @@ func (self *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 		return nil
 	}
 
+	exp.Rbracket = self.curToken
+
 	return exp
 }
 
@@ -475,5 +686,8 @@ func (self *Parser) parseHashLiteral() ast.Expression {
 	if !self.expectPeek(token.RBRACE) {
 		return nil
 	}
+
+	hash.Rbrace = self.curToken
+
 	return hash
 }
\ No newline at end of file
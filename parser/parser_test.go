@@ -295,7 +295,7 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		}, 
 		{
 			"3 + 4; -5 * 5",
-			"(3 + 4)((-5) * 5)",
+			"(3 + 4); ((-5) * 5)",
     	},
 		{
 			"5 > 4 == 3 < 4",
@@ -365,6 +365,54 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"x = y + 1",
+			"x = (y + 1)",
+		},
+		{
+			"a = b += c * 2",
+			"a = b += (c * 2)",
+		},
+		{
+			"x -= y == z",
+			"x -= (y == z)",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a % b * c",
+			"((a % b) * c)",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"a == b && c < d || !e",
+			"(((a == b) && (c < d)) || (!e))",
+		},
+		{
+			"x = a || b",
+			"x = (a || b)",
+		},
+		{
+			"a[i + 1] = b[0] *= 2",
+			"(a[(i + 1)]) = (b[0]) *= 2",
+		},
 	}
 
 	for _, test := range tests {
@@ -453,6 +501,69 @@ func TestIfExpression(t *testing.T) {
 
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue; }`
+	lex := lexer.New(input)
+	parser := New(lex)
+	program := parser.ParseProgram()
+
+	checkParserErrors(t, parser)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body is not 3 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ast.ContinueStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in xs) { x }`
+	lex := lexer.New(input)
+	parser := New(lex)
+	program := parser.ParseProgram()
+
+	checkParserErrors(t, parser)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "x") { return }
+	if !testIdentifier(t, stmt.Iterable, "xs") { return }
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if stmt.String() != "for (x in xs) { x }" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y }`
 
@@ -692,7 +803,7 @@ func TestParsingHashLiteralsStringKey(t *testing.T) {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
 		}
 
-		expectedValue := expected[literal.String()]
+		expectedValue := expected[literal.Value]
 
 		testIntegerLiteral(t, value, expectedValue)
 	}
@@ -749,9 +860,9 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			continue
 		}
 
-		testFunc, ok := tests[literal.String()]
+		testFunc, ok := tests[literal.Value]
 		if !ok {
-			t.Errorf("No test function for key %q found", literal.String())
+			t.Errorf("No test function for key %q found", literal.Value)
 			continue
 		}
 
@@ -849,3 +960,224 @@ func testBooleanLiteral(t *testing.T, expression ast.Expression, value bool) boo
 	return true
 }
 
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	lex := lexer.New(input)
+	parser := New(lex)
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n", function.Name)
+	}
+}
+
+func TestLetStatementDocComment(t *testing.T) {
+	input := `// answer is the answer.
+let answer = 42;`
+
+	lex := lexer.New(input)
+	parser := New(lex)
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.Token.Doc != "answer is the answer." {
+		t.Fatalf("let statement doc wrong. got=%q", stmt.Token.Doc)
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct{
+		input 			string
+		expectedError 	string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"1 +\n  ;", "2:3: no prefix parse function for ; found"},
+		{"99999999999999999999", "1:1: could not parse \"99999999999999999999\" as integer"},
+		{"let x = 5; /* open", "1:12: unterminated block comment"},
+		{"break;", "1:1: break outside loop"},
+		{"1 + 2 = 3", "1:7: cannot assign to (1 + 2)"},
+		{"while (true) { fn() { continue; } }", "1:23: continue outside loop"},
+		{"while (true) { let x = if (true) { break; }; }", "1:36: break inside an expression"},
+		{"for (x in [1]) { puts(1 + if (x > 0) { continue; } else { 2 }); }", "1:40: continue inside an expression"},
+		{"while (true) { if (true) { break; } else { 1 } + 1; }", "1:28: break inside an expression"},
+		{"while (true) { if (true) { if (true) { break; } }[0]; }", "1:40: break inside an expression"},
+	}
+
+	for _, test := range tests {
+		lex := lexer.New(test.input)
+		parser := New(lex)
+		parser.ParseProgram()
+
+		errors := parser.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", test.input)
+			continue
+		}
+
+		if errors[0] != test.expectedError {
+			t.Errorf("wrong parser error for %q. want=%q, got=%q", test.input, test.expectedError, errors[0])
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2][0]);`
+
+	lex := lexer.New(input)
+	parser := New(lex)
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	function := let.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+
+	tests := []struct{
+		node 		ast.Node
+		startLine 	int
+		startColumn int
+		endLine 	int
+		endColumn 	int
+	}{
+		{program, 	1, 1, 4, 15},
+		{let, 		1, 1, 3, 2},
+		{function, 	1, 11, 3, 2},
+		{body, 		2, 3, 2, 8},
+		{call, 		4, 1, 4, 15},
+		{index, 	4, 8, 4, 14},
+	}
+
+	for _, test := range tests {
+		pos := test.node.Pos()
+		end := test.node.End()
+
+		if pos.Line != test.startLine || pos.Column != test.startColumn {
+			t.Errorf("wrong start for %q. want=%d:%d, got=%s",
+				test.node.String(), test.startLine, test.startColumn, pos)
+		}
+
+		if end.Line != test.endLine || end.Column != test.endColumn {
+			t.Errorf("wrong end for %q. want=%d:%d, got=%s",
+				test.node.String(), test.endLine, test.endColumn, end)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "3.25;"
+
+	lex := lexer.New(input)
+	parser := New(lex)
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Value != 3.25 {
+		t.Errorf("literal.Value not %f. got=%f", 3.25, literal.Value)
+	}
+
+	if literal.TokenLiteral() != "3.25" {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", "3.25", literal.TokenLiteral())
+	}
+}
+
+func TestProgramStringRoundTrip(t *testing.T) {
+	tests := []string{
+		`let x = 1; x; -x; x + 2 * 3`,
+		`if (x < y) { x } else { y }; if (a) { b }`,
+		`let f = fn(a, b) { let c = a; return c + b; }; f(1, 2); fn() { }()`,
+		`let s = "hello world"; puts(s, [1, "two", true])`,
+		`let h = {"b": 2, 1: [3], true: {}}; h["b"]; h[1][0]`,
+		`while (i < 3) { i += 1; if (i == 2) { continue; } break; }`,
+		`for (x in xs) { puts(x) }; a[0] = b = 2`,
+		`!(a && b) || c ** -2 ** 3 % 4 >= 1.5`,
+	}
+
+	for _, input := range tests {
+		printed := parseProgram(t, input).String()
+		reprinted := parseProgram(t, printed).String()
+
+		if printed != reprinted {
+			t.Errorf("String() does not round-trip for %q.\nfirst=%q\nsecond=%q", input, printed, reprinted)
+		}
+	}
+}
+
+func parseProgram(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	parser := New(lexer.New(input))
+	program := parser.ParseProgram()
+	checkParserErrors(t, parser)
+
+	return program
+}
+
+// FuzzParseProgram checks that the parser never panics and that every
+// program it accepts prints as source that parses back to the same program.
+func FuzzParseProgram(f *testing.F) {
+	f.Add(`let x = fn(a) { if (a > 1) { a * x(a - 1) } else { 1 } }; x(5)`)
+	f.Add(`let h = {"a": [1, 2.5], 2: true}; h["a"][1] += 1;`)
+	f.Add(`for (c in "abc") { while (true) { break; } continue; }`)
+	f.Add(`/* block /* nested */ */ puts(!-a ** b % c <= d) // line`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		parser := New(lexer.New(input))
+		program := parser.ParseProgram()
+		if len(parser.Errors()) != 0 {
+			return
+		}
+
+		printed := program.String()
+		reparser := New(lexer.New(printed))
+		reparsed := reparser.ParseProgram()
+		if len(reparser.Errors()) != 0 {
+			t.Fatalf("printed program does not parse: %q\nprinted=%q\nerrors=%v", input, printed, reparser.Errors())
+		}
+
+		if reparsed.String() != printed {
+			t.Fatalf("printed program parses differently: %q\nfirst=%q\nsecond=%q", input, printed, reparsed.String())
+		}
+	})
+}
@@ -4,7 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"strings"
+	"bear/asm"
+	"bear/ast"
 	"bear/compiler"
+	"bear/evaluator"
 	"bear/lexer"
 	"bear/parser"
 	"bear/vm"
@@ -13,22 +17,64 @@ import (
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	//env := object.NewEnvironment()
+// DISASM_COMMAND prints the bytecode of the code that follows it instead
+// of running it.
+const DISASM_COMMAND = ":disasm"
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
+// Engines that can run the programs typed into the REPL.
+const (
+	ENGINE_VM 	= "vm"
+	ENGINE_EVAL = "eval"
+)
+
+// session runs the lines read by the REPL, keeping their definitions for
+// the lines that follow. run reports errors to out itself and returns the
+// value to print, or nil if there is none.
+type session interface {
+	run(program *ast.Program, out io.Writer) object.Object
+
+	// compile compiles program against the session's definitions
+	// without running it or changing the session.
+	compile(program *ast.Program) (*compiler.Bytecode, error)
+}
+
+func newSession(engine string) (session, error) {
+	switch engine {
+	case ENGINE_VM:
+		return newVMSession(), nil
+	case ENGINE_EVAL:
+		return &evalSession{env: object.NewEnvironment()}, nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want %s or %s", engine, ENGINE_VM, ENGINE_EVAL)
+	}
+}
+
+func Start(in io.Reader, out io.Writer, engine string) error {
+	session, err := newSession(engine)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(in)
 
 	for {
 		fmt.Fprintf(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
-			return
+			return nil
 		}
 
 		line := scanner.Text()
+
+		disasm := strings.HasPrefix(strings.TrimSpace(line), DISASM_COMMAND)
+		if disasm {
+			line = strings.TrimPrefix(strings.TrimSpace(line), DISASM_COMMAND)
+			if strings.TrimSpace(line) == "" {
+				fmt.Fprintf(out, "usage: %s <code>\n", DISASM_COMMAND)
+				continue
+			}
+		}
+
 		lex := lexer.New(line)
 		par := parser.New(lex)
 
@@ -38,30 +84,103 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Whoops! Compilation failed:\n %s\n", err)
+		if disasm {
+			bytecode, err := session.compile(program)
+			if err != nil {
+				fmt.Fprintf(out, "Whoops! Compilation failed:\n %s\n", err)
+				continue
+			}
+			io.WriteString(out, asm.Disassemble(bytecode))
 			continue
 		}
 
-		code := comp.Bytecode()
-		constants = code.Constants
-
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Whoops! Executing bytecode failed:\n %s\n", err)
-			continue
+		result := session.run(program, out)
+		if result != nil {
+			io.WriteString(out, result.Inspect())
+			io.WriteString(out, "\n")
 		}
+	}
+}
+
+type vmSession struct {
+	constants 	[]object.Object
+	globals 	[]object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newVMSession() *vmSession {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
 
-		lastPopped := machine.LastPoppedStackElem()
-		io.WriteString(out, lastPopped.Inspect())
-		io.WriteString(out, "\n")
-		
+	return &vmSession{
+		constants: 		[]object.Object{},
+		globals: 		make([]object.Object, vm.GlobalsSize),
+		symbolTable: 	symbolTable,
 	}
 }
 
+// run compiles program against a copy of the session's definitions, so
+// that a line that fails to compile defines nothing. Once it has run, the
+// names whose let statement never ran, because of an error or a branch
+// not taken, are forgotten again, as they are on the evaluator.
+func (self *vmSession) run(program *ast.Program, out io.Writer) object.Object {
+	symbolTable := self.symbolTable.Copy()
+	comp := compiler.NewWithState(symbolTable, self.constants)
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(out, "Whoops! Compilation failed:\n %s\n", err)
+		return nil
+	}
+
+	code := comp.Bytecode()
+	self.constants = code.Constants
+	self.symbolTable = symbolTable
+
+	defer symbolTable.Forget(func(s compiler.Symbol) bool {
+		return s.Scope == compiler.GlobalScope && self.globals[s.Index] == nil
+	})
+
+	machine := vm.NewWithGlobalsStore(code, self.globals)
+	err = machine.Run()
+	if err != nil {
+		printRuntimeError(out, err)
+		return nil
+	}
+
+	return machine.LastPoppedStackElem()
+}
+
+func (self *vmSession) compile(program *ast.Program) (*compiler.Bytecode, error) {
+	constants := append([]object.Object{}, self.constants...)
+	comp := compiler.NewWithState(self.symbolTable.Copy(), constants)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	return comp.Bytecode(), nil
+}
+
+type evalSession struct {
+	env *object.Environment
+}
+
+func (self *evalSession) run(program *ast.Program, out io.Writer) object.Object {
+	result := evaluator.Eval(program, self.env)
+	if err, ok := result.(*object.Error); ok {
+		printRuntimeError(out, err)
+		return nil
+	}
+
+	return result
+}
+
+// compile compiles program on its own; the compiler cannot see the
+// definitions in the evaluator's environment.
+func (self *evalSession) compile(program *ast.Program) (*compiler.Bytecode, error) {
+	return newVMSession().compile(program)
+}
+
 const ERROR_FACE = `
 ʕ⊙ᴥ⊙ʔ
 `
@@ -74,4 +193,17 @@ func printParserErrors(out io.Writer, errors []string) {
 		io.WriteString(out, "ℹ️  ")
 		io.WriteString(out, msg+"\n\n")
 	}
-}
\ No newline at end of file
+}
+
+// printRuntimeError reports an error raised while running a program on
+// either engine, with a backtrace if the VM recorded one.
+func printRuntimeError(out io.Writer, err error) {
+	io.WriteString(out, ERROR_FACE)
+	io.WriteString(out, "Whoops! Execution failed:\n")
+
+	if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+		io.WriteString(out, runtimeErr.Backtrace())
+		return
+	}
+	fmt.Fprintln(out, err)
+}
@@ -1,10 +1,40 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
-	Type TokenType
+	Type 	TokenType
 	Literal string
+	Pos 	Position // position of the first character of the token
+	End 	Position // position immediately after the token
+	Doc 	string // comment directly above a let token, if any
+}
+
+// Position describes a location in the source. Lines and columns
+// start at 1, the byte offset starts at 0.
+type Position struct {
+	Filename 	string
+	Offset 		int
+	Line 		int
+	Column 		int
+}
+
+func (self Position) IsValid() bool { return self.Line > 0 }
+
+func (self Position) String() string {
+	if !self.IsValid() {
+		if self.Filename != "" {
+			return self.Filename
+		}
+		return "-"
+	}
+
+	if self.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", self.Filename, self.Line, self.Column)
+	}
+	return fmt.Sprintf("%d:%d", self.Line, self.Column)
 }
 
 const (
@@ -14,6 +44,7 @@ const (
 	// identifiers + literals
 	IDENT 		= "IDENT" // foobar, x, y
 	INT 		= "INT"
+	FLOAT 		= "FLOAT"
 
 	// operators
 	ASSIGN 		= "="
@@ -22,11 +53,22 @@ const (
 	BANG 		= "!"
 	ASTERISK 	= "*"
 	SLASH 		= "/"
+	PERCENT 	= "%"
+	POWER 		= "**"
+
+	PLUS_ASSIGN 	= "+="
+	MINUS_ASSIGN 	= "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN 	= "/="
 
 	LT 			= "<"
 	GT 			= ">"
+	LT_EQ 		= "<="
+	GT_EQ 		= ">="
 	EQ 			= "=="
 	NOT_EQ 		= "!="
+	AND 		= "&&"
+	OR 			= "||"
 
 	// delimiters
 	COMMA 		= ","
@@ -46,6 +88,11 @@ const (
 	IF 			= "IF"
 	ELSE 		= "ELSE"
 	RETURN 		= "RETURN"
+	WHILE 		= "WHILE"
+	FOR 		= "FOR"
+	IN 			= "IN"
+	BREAK 		= "BREAK"
+	CONTINUE 	= "CONTINUE"
 	STRING 		= "STRING"
 	LBRACKET 	= "["
 	RBRACKET 	= "]"
@@ -59,6 +106,11 @@ var keywords = map[string]TokenType{
 	"if":		IF,
 	"else": 	ELSE,
 	"return": 	RETURN,
+	"while": 	WHILE,
+	"for": 		FOR,
+	"in": 		IN,
+	"break": 	BREAK,
+	"continue": CONTINUE,
 }
 
 func LookupIdent(ident string) TokenType {
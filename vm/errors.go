@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"bear/token"
+)
+
+// RuntimeError is returned by Run when executing bytecode fails. Trace
+// holds the call stack at the time of the failure, innermost call first.
+type RuntimeError struct {
+	Message string
+	Pos 	token.Position
+	Trace 	[]TraceFrame
+}
+
+// maxBacktraceFrames limits how many calls Backtrace prints; deeper
+// traces are elided in the middle.
+const maxBacktraceFrames = 16
+
+// TraceFrame describes one active call in a RuntimeError's trace.
+type TraceFrame struct {
+	Function 	string
+	Offset 		int // offset of the instruction being executed
+	Pos 		token.Position
+}
+
+func (self *RuntimeError) Error() string {
+	if self.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", self.Pos, self.Message)
+	}
+	return self.Message
+}
+
+// Backtrace formats the error followed by one line per active call.
+func (self *RuntimeError) Backtrace() string {
+	var out bytes.Buffer
+
+	out.WriteString(self.Error())
+	out.WriteString("\n")
+
+	for i, frame := range self.Trace {
+		if len(self.Trace) > maxBacktraceFrames {
+			if i == maxBacktraceFrames / 2 {
+				elided := len(self.Trace) - maxBacktraceFrames
+				fmt.Fprintf(&out, "    ... %d more calls ...\n", elided)
+			}
+
+			if i >= maxBacktraceFrames / 2 && i < len(self.Trace) - maxBacktraceFrames / 2 {
+				continue
+			}
+		}
+
+		fmt.Fprintf(&out, "    at %s (%s) ip=%04d\n", frame.Function, frame.Pos, frame.Offset)
+	}
+
+	return out.String()
+}
+
+func (self *VM) newRuntimeError(err error) *RuntimeError {
+	trace := make([]TraceFrame, 0, self.framesIndex)
+
+	for i := self.framesIndex - 1 ; i >= 0 ; i-- {
+		frame := self.frames[i]
+		trace = append(trace, TraceFrame{
+			Function: 	frame.name(),
+			Offset: 	frame.offset(),
+			Pos: 		frame.position(),
+		})
+	}
+
+	return &RuntimeError{Message: err.Error(), Pos: trace[0].Pos, Trace: trace}
+}
@@ -3,22 +3,48 @@ package vm
 import (
 	"bear/code"
 	"bear/object"
+	"bear/token"
 )
 
 type Frame struct {
-	fn 			*object.CompiledFunction
+	cl 			*object.Closure
 	ip 			int
 	basePointer int
 }
 
-func NewFrame(fn *object.CompiledFunction, basePointer int) *Frame {
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
 	return &Frame{
-		fn: fn, 
+		cl: cl,
 		ip: -1,
 		basePointer: basePointer,
 	}
 }
 
 func (self *Frame) Instructions() code.Instructions {
-	return self.fn.Instructions
-}
\ No newline at end of file
+	return self.cl.Fn.Instructions
+}
+
+// offset returns the offset of the instruction the frame is currently
+// executing.
+func (self *Frame) offset() int {
+	if self.ip < 0 {
+		return 0
+	}
+	return self.Instructions().InstructionStart(self.ip)
+}
+
+// position returns the source position of the instruction the frame is
+// currently executing.
+func (self *Frame) position() token.Position {
+	if self.ip < 0 {
+		return token.Position{}
+	}
+	return self.cl.Fn.SourceMap[self.offset()]
+}
+
+func (self *Frame) name() string {
+	if self.cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return self.cl.Fn.Name
+}
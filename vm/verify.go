@@ -0,0 +1,285 @@
+package vm
+
+import (
+	"bear/code"
+	"bear/compiler"
+	"bear/object"
+	"fmt"
+)
+
+// Verify checks that bytecode is safe to run before the VM trusts it. The
+// VM itself does not check what the compiler guarantees, so bytecode read
+// from a file must be verified first. Verify checks the main program and
+// every compiled function in the constant pool:
+//
+//   - every opcode is defined and no instruction is cut short
+//   - jumps land on the start of an instruction
+//   - constant, global, local, builtin and free variable indexes are in
+//     range
+//   - every path through the instructions sees the same stack depth at
+//     each instruction, never pops more values than were pushed and ends
+//     a function with a return
+//
+// The error names the function and the offset of the first problem found.
+// Whether a variable is set before it is read is not checked: the VM
+// reports reading an uninitialized variable as a runtime error.
+func Verify(bytecode *compiler.Bytecode) error {
+	routines := []*routine{
+		{name: "main", ins: bytecode.Instructions, main: true},
+	}
+	functions := map[int]*routine{}
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		r := &routine{
+			name: 		fmt.Sprintf("function %d (%s)", i, name),
+			ins: 		fn.Instructions,
+			numLocals: 	fn.NumLocals,
+			numFree: 	-1,
+		}
+
+		if fn.NumParameters < 0 || fn.NumLocals < fn.NumParameters {
+			return fmt.Errorf("%s: %d parameters but %d locals", r.name, fn.NumParameters, fn.NumLocals)
+		}
+
+		functions[i] = r
+		routines = append(routines, r)
+	}
+
+	for _, r := range routines {
+		if err := r.decode(); err != nil {
+			return err
+		}
+	}
+
+	// A function's closures are made by OpClosure, which says how many
+	// free variables they capture. A function no closure is made of can
+	// never run, so its free variable indexes are not checked.
+	for _, r := range routines {
+		for _, offset := range r.offsets {
+			in := r.instructions[offset]
+			if in.op != code.OpClosure {
+				continue
+			}
+
+			index := in.operands[0]
+			if index >= len(bytecode.Constants) {
+				return r.errorf(offset, "constant %d out of range (%d constants)", index, len(bytecode.Constants))
+			}
+
+			fn, ok := functions[index]
+			if !ok {
+				return r.errorf(offset, "constant %d is %s, not a function", index, bytecode.Constants[index].Type())
+			}
+
+			if fn.numFree >= 0 && fn.numFree != in.operands[1] {
+				return r.errorf(offset, "%s captures %d free variables here and %d elsewhere",
+					fn.name, in.operands[1], fn.numFree)
+			}
+			fn.numFree = in.operands[1]
+		}
+	}
+
+	for _, r := range routines {
+		if err := r.checkOperands(bytecode.Constants); err != nil {
+			return err
+		}
+		if err := r.checkStack(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// routine is the main program or a compiled function being verified.
+type routine struct {
+	name 			string
+	ins 			code.Instructions
+	main 			bool
+	numLocals 		int
+	numFree 		int // -1 if unknown
+
+	offsets 		[]int // of each instruction, in order
+	instructions 	map[int]*decodedInstruction
+}
+
+type decodedInstruction struct {
+	op 			code.Opcode
+	def 		*code.Definition
+	operands 	[]int
+	next 		int // offset of the following instruction
+}
+
+func (self *routine) errorf(offset int, format string, a ...interface{}) error {
+	return fmt.Errorf("%s at %04d: %s", self.name, offset, fmt.Sprintf(format, a...))
+}
+
+// decode splits the instructions up, checking every opcode is defined
+// and has all of its operands.
+func (self *routine) decode() error {
+	self.instructions = map[int]*decodedInstruction{}
+
+	offset := 0
+	for offset < len(self.ins) {
+		def, err := code.Lookup(self.ins[offset])
+		if err != nil {
+			return self.errorf(offset, "%s", err)
+		}
+
+		if offset + def.InstructionWidth() > len(self.ins) {
+			return self.errorf(offset, "truncated %s", def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, self.ins[offset + 1:])
+		self.offsets = append(self.offsets, offset)
+		self.instructions[offset] = &decodedInstruction{
+			op: 		code.Opcode(self.ins[offset]),
+			def: 		def,
+			operands: 	operands,
+			next: 		offset + 1 + read,
+		}
+
+		offset += 1 + read
+	}
+
+	return nil
+}
+
+func (self *routine) checkOperands(constants []object.Object) error {
+	for _, offset := range self.offsets {
+		in := self.instructions[offset]
+
+		switch in.op {
+		case code.OpConstant:
+			if in.operands[0] >= len(constants) {
+				return self.errorf(offset, "constant %d out of range (%d constants)", in.operands[0], len(constants))
+			}
+
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy:
+			target := in.operands[0]
+			if _, ok := self.instructions[target]; !ok && target != len(self.ins) {
+				return self.errorf(offset, "jump to %04d is not the start of an instruction", target)
+			}
+
+		case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+			if in.operands[0] >= self.numLocals {
+				return self.errorf(offset, "local %d out of range (%d locals)", in.operands[0], self.numLocals)
+			}
+
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if in.operands[0] >= GlobalsSize {
+				return self.errorf(offset, "global %d out of range", in.operands[0])
+			}
+
+		case code.OpGetBuiltin:
+			if in.operands[0] >= len(object.Builtins) {
+				return self.errorf(offset, "builtin %d out of range (%d builtins)", in.operands[0], len(object.Builtins))
+			}
+
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			if self.numFree >= 0 && in.operands[0] >= self.numFree {
+				return self.errorf(offset, "free variable %d out of range (%d free variables)", in.operands[0], self.numFree)
+			}
+
+		case code.OpHash:
+			if in.operands[0] % 2 != 0 {
+				return self.errorf(offset, "odd number of hash elements %d", in.operands[0])
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkStack follows every path through the instructions, working out
+// the stack depth before each of them.
+func (self *routine) checkStack() error {
+	depths := map[int]int{0: 0}
+	work := []int{0}
+
+	for len(work) > 0 {
+		offset := work[len(work) - 1]
+		work = work[:len(work) - 1]
+		depth := depths[offset]
+
+		if offset == len(self.ins) {
+			if !self.main {
+				return self.errorf(offset, "end of function reached without a return")
+			}
+			continue
+		}
+
+		flow := func(target int, targetDepth int) error {
+			if seen, ok := depths[target]; ok {
+				if seen != targetDepth {
+					return self.errorf(offset, "stack depth %d at %04d does not match %d on another path",
+						targetDepth, target, seen)
+				}
+				return nil
+			}
+			depths[target] = targetDepth
+			work = append(work, target)
+			return nil
+		}
+
+		in := self.instructions[offset]
+
+		// OpIterNext leaves the iterator, an element and true on the
+		// stack, or replaces the iterator with false, so the
+		// OpJumpNotTruthy after it leaves two values fewer where it jumps
+		// to than where it falls through to.
+		if in.op == code.OpIterNext {
+			if depth < 1 {
+				return self.errorf(offset, "stack underflow: %s needs an iterator", in.def.Name)
+			}
+
+			test, ok := self.instructions[in.next]
+			if !ok || test.op != code.OpJumpNotTruthy {
+				return self.errorf(offset, "%s is not followed by OpJumpNotTruthy", in.def.Name)
+			}
+
+			if err := flow(test.next, depth + 1); err != nil {
+				return err
+			}
+			if err := flow(test.operands[0], depth - 1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		pops, pushes := in.def.StackEffect(in.operands)
+		if depth < pops {
+			return self.errorf(offset, "stack underflow: %s pops %d values, the stack has %d",
+				in.def.Name, pops, depth)
+		}
+		after := depth - pops + pushes
+
+		var err error
+		switch in.op {
+		case code.OpReturn, code.OpReturnValue:
+		case code.OpJump:
+			err = flow(in.operands[0], after)
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
+			err = flow(in.operands[0], after)
+			if err == nil {
+				err = flow(in.next, after)
+			}
+		default:
+			err = flow(in.next, after)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package vm
+
+import (
+	"bear/code"
+	"bear/compiler"
+	"bear/object"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCompilerOutput(t *testing.T) {
+	inputs := []string{
+		"",
+		"1 + 2; puts(len([1, 2, 3]));",
+		`let h = {"a": 1, "b": [2, 3]}; h["a"] = h["b"][0];`,
+		"let f = fn(a, b) { if (a > b) { return a; } b }; f(1, 2);",
+		"let adder = fn(x) { fn(y) { x = x + y; x } }; adder(1)(2);",
+		"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(4);",
+		"let total = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } total += x; }",
+		"let f = fn() { let i = 0; while (true) { i += 1; if (i > 3) { break; } } for (x in [1]) { return x; } }; f();",
+		"let f = fn(a) { a && !a || -1 <= 2 ** 3 % 4 }; f(true);",
+		"return 1;",
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", input, err)
+		}
+
+		if err := Verify(comp.Bytecode()); err != nil {
+			t.Errorf("%q: verify error: %s", input, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: instructions(ins...), NumLocals: numLocals, Name: "f"}
+	}
+
+	tests := []struct {
+		main 		code.Instructions
+		constants 	[]object.Object
+		expected 	string
+	}{
+		{
+			code.Instructions{255},
+			nil,
+			"main at 0000: opcode 255 undefined",
+		},
+		{
+			code.Make(code.OpConstant, 0)[:2],
+			nil,
+			"main at 0000: truncated OpConstant",
+		},
+		{
+			instructions(code.Make(code.OpConstant, 1), code.Make(code.OpPop)),
+			[]object.Object{&object.Integer{Value: 1}},
+			"main at 0000: constant 1 out of range (1 constants)",
+		},
+		{
+			instructions(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2), code.Make(code.OpNull)),
+			nil,
+			"main at 0001: jump to 0002 is not the start of an instruction",
+		},
+		{
+			instructions(code.Make(code.OpGetLocal, 0)),
+			nil,
+			"main at 0000: local 0 out of range (0 locals)",
+		},
+		{
+			instructions(code.Make(code.OpGetBuiltin, 200)),
+			nil,
+			"main at 0000: builtin 200 out of range",
+		},
+		{
+			instructions(code.Make(code.OpNull), code.Make(code.OpHash, 1)),
+			nil,
+			"main at 0001: odd number of hash elements 1",
+		},
+		{
+			instructions(code.Make(code.OpPop)),
+			nil,
+			"main at 0000: stack underflow: OpPop pops 1 values, the stack has 0",
+		},
+		{
+			instructions(code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpCall, 2)),
+			nil,
+			"main at 0002: stack underflow: OpCall pops 3 values, the stack has 2",
+		},
+		{
+			// the value pushed on one branch is not there on the other
+			instructions(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 7),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			),
+			nil,
+			"does not match",
+		},
+		{
+			instructions(code.Make(code.OpNull), code.Make(code.OpIterNext), code.Make(code.OpPop)),
+			nil,
+			"main at 0001: OpIterNext is not followed by OpJumpNotTruthy",
+		},
+		{
+			instructions(code.Make(code.OpClosure, 0, 0)),
+			[]object.Object{&object.String{Value: "f"}},
+			"main at 0000: constant 0 is STRING, not a function",
+		},
+		{
+			instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{fn(0, code.Make(code.OpNull))},
+			"function 0 (f) at 0001: end of function reached without a return",
+		},
+		{
+			instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{fn(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			"function 0 (f) at 0000: local 1 out of range (1 locals)",
+		},
+		{
+			instructions(code.Make(code.OpNull), code.Make(code.OpClosure, 0, 1), code.Make(code.OpPop)),
+			[]object.Object{fn(0, code.Make(code.OpGetFree, 1), code.Make(code.OpReturnValue))},
+			"function 0 (f) at 0000: free variable 1 out of range (1 free variables)",
+		},
+		{
+			instructions(
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpClosure, 0, 1),
+			),
+			[]object.Object{fn(0, code.Make(code.OpReturn))},
+			"main at 0005: function 0 (f) captures 1 free variables here and 0 elsewhere",
+		},
+		{
+			nil,
+			[]object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpReturn), NumParameters: 2, NumLocals: 1}},
+			"function 0 (<anonymous>): 2 parameters but 1 locals",
+		},
+	}
+
+	for _, test := range tests {
+		bytecode := &compiler.Bytecode{Instructions: test.main, Constants: test.constants}
+
+		err := Verify(bytecode)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("wrong error for\n%s\nwant=%q, got=%v", test.main, test.expected, err)
+		}
+	}
+}
+
+// TestVerifiedUninitializedReads runs bytecode that passes the verifier
+// but reads variables nothing has set, which must fail without crashing.
+func TestVerifiedUninitializedReads(t *testing.T) {
+	tests := []*compiler.Bytecode{
+		{
+			Instructions: instructions(
+				code.Make(code.OpGetGlobal, 5),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			),
+		},
+		{
+			Instructions: instructions(
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			),
+			Constants: []object.Object{
+				&object.CompiledFunction{
+					Instructions: instructions(
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					),
+					NumLocals: 	1,
+					Name: 		"f",
+				},
+			},
+		},
+		{
+			// f reads the slot an earlier call of g set
+			Instructions: instructions(
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			),
+			Constants: []object.Object{
+				&object.CompiledFunction{
+					Instructions: instructions(
+						code.Make(code.OpTrue),
+						code.Make(code.OpSetLocal, 0),
+						code.Make(code.OpReturn),
+					),
+					NumLocals: 	1,
+					Name: 		"g",
+				},
+				&object.CompiledFunction{
+					Instructions: instructions(
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpReturnValue),
+					),
+					NumLocals: 	1,
+					Name: 		"f",
+				},
+			},
+		},
+	}
+
+	for _, bytecode := range tests {
+		if err := Verify(bytecode); err != nil {
+			t.Fatalf("verify error: %s", err)
+		}
+
+		err := New(bytecode).Run()
+		if err == nil || !strings.Contains(err.Error(), "uninitialized variable") {
+			t.Errorf("wrong VM error for\n%s\nwant=%q, got=%v", bytecode.Instructions, "uninitialized variable", err)
+		}
+	}
+}
+
+func instructions(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
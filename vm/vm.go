@@ -5,6 +5,7 @@ import (
 	"bear/code"
 	"bear/compiler"
 	"bear/object"
+	"math"
 )
 
 const StackSize = 2048
@@ -15,6 +16,10 @@ var Null = &object.Null{}
 
 const MaxFrames = 1024
 
+// errUninitialized is returned when a variable is read before the let
+// statement that binds it has run.
+var errUninitialized = fmt.Errorf("uninitialized variable")
+
 type VM struct {
 	constants 	 []object.Object
 
@@ -25,6 +30,10 @@ type VM struct {
 
 	frames 		 []*Frame
 	framesIndex	 int
+
+	// CheckOverflow makes integer arithmetic that overflows int64 a
+	// runtime error instead of wrapping around.
+	CheckOverflow bool
 }
 
 func (self *VM) currentFrame() *Frame {
@@ -42,8 +51,13 @@ func (self *VM) popFrame() *Frame {
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
-	mainFrame := NewFrame(mainFn, 0)
+	mainFn := &object.CompiledFunction{
+		Instructions: 	bytecode.Instructions,
+		SourceMap: 		bytecode.SourceMap,
+		Name: 			"<main>",
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
@@ -69,6 +83,14 @@ func (self *VM) StackTop() object.Object {
 }
 
 func (self *VM) Run() error {
+	err := self.run()
+	if err != nil {
+		return self.newRuntimeError(err)
+	}
+	return nil
+}
+
+func (self *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -92,7 +114,7 @@ func (self *VM) Run() error {
 				return err
 			}
 
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow:
 			err := self.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -113,7 +135,7 @@ func (self *VM) Run() error {
 				return err
 			}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterEqual, code.OpLessThan, code.OpLessEqual:
 			err := self.executeComparison(op)
 			if err != nil {
 				return err
@@ -144,6 +166,15 @@ func (self *VM) Run() error {
 				self.currentFrame().ip = pos - 1
 			}
 
+		case code.OpJumpTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			self.currentFrame().ip += 2
+
+			condition := self.pop()
+			if isTruthy(condition) {
+				self.currentFrame().ip = pos - 1
+			}
+
 		case code.OpNull:
 			err := self.push(Null)
 			if err != nil {
@@ -159,7 +190,12 @@ func (self *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip + 1:])
 			self.currentFrame().ip += 2
 
-			err := self.push(self.globals[globalIndex])
+			global := self.globals[globalIndex]
+			if global == nil {
+				return errUninitialized
+			}
+
+			err := self.push(global)
 			if err != nil {
 				return err
 			}
@@ -188,13 +224,17 @@ func (self *VM) Run() error {
 
 			self.sp = self.sp - numberElems
 
-			err = self.push(hash)
-
 			err = self.push(hash)
 			if err != nil {
 				return err
 			}
 
+		case code.OpCheckHashKey:
+			key := self.stack[self.sp - 1]
+			if _, ok := key.(object.Hashable); !ok {
+				return fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+
 		case code.OpIndex:
 			index := self.pop()
 			left := self.pop()
@@ -205,18 +245,22 @@ func (self *VM) Run() error {
 			}
 
 		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip + 1:])
 			self.currentFrame().ip += 1
-			fn, ok := self.stack[self.sp - 1].(*object.CompiledFunction)
-			if !ok {
-				return fmt.Errorf("calling non-function")
+
+			err := self.executeCall(int(numArgs))
+			if err != nil {
+				return err
 			}
-			frame := NewFrame(fn, self.sp)
-			self.pushFrame(frame)
-			self.sp = frame.basePointer + fn.NumLocals
 
 		case code.OpReturnValue:
 			returnValue := self.pop()
 
+			// a return at the top level ends the program
+			if self.framesIndex == 1 {
+				return nil
+			}
+
 			frame := self.popFrame()
 			self.sp = frame.basePointer - 1
 
@@ -226,6 +270,10 @@ func (self *VM) Run() error {
 			}
 			
 		case code.OpReturn:
+			if self.framesIndex == 1 {
+				return nil
+			}
+
 			frame := self.popFrame()
 			self.sp = frame.basePointer - 1
 
@@ -239,8 +287,13 @@ func (self *VM) Run() error {
 			self.currentFrame().ip += 1
 
 			frame := self.currentFrame()
+			slot := &self.stack[frame.basePointer+int(localIndex)]
 
-			self.stack[frame.basePointer+int(localIndex)] = self.pop()
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = self.pop()
+			} else {
+				*slot = self.pop()
+			}
 
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip + 1:])
@@ -248,16 +301,244 @@ func (self *VM) Run() error {
 
 			frame := self.currentFrame()
 
-			err := self.push(self.stack[frame.basePointer+int(localIndex)])
+			local := self.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := local.(*object.Cell); ok {
+				local = cell.Value
+			}
+			if local == nil {
+				return errUninitialized
+			}
+
+			err := self.push(local)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip + 1:])
+			self.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+
+			err := self.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip + 1:])
+			numFree := code.ReadUint8(ins[ip + 3:])
+			self.currentFrame().ip += 3
+
+			err := self.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip + 1:])
+			self.currentFrame().ip += 1
+
+			currentClosure := self.currentFrame().cl
+			value := currentClosure.Free[freeIndex].(*object.Cell).Value
+			if value == nil {
+				return errUninitialized
+			}
+
+			err := self.push(value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := self.currentFrame().cl
+			err := self.push(currentClosure)
 			if err != nil {
 				return err
 			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip + 1:])
+			self.currentFrame().ip += 1
+
+			frame := self.currentFrame()
+			slot := &self.stack[frame.basePointer+int(localIndex)]
+
+			cell, ok := (*slot).(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
+
+			err := self.push(cell)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip + 1:])
+			self.currentFrame().ip += 1
+
+			err := self.push(self.currentFrame().cl.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := self.pop()
+			index := self.pop()
+			left := self.pop()
+
+			err := self.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDupPair:
+			err := self.push(self.stack[self.sp - 2])
+			if err != nil {
+				return err
+			}
+
+			err = self.push(self.stack[self.sp - 2])
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip + 1:])
+			self.currentFrame().ip += 1
+
+			self.currentFrame().cl.Free[freeIndex].(*object.Cell).Value = self.pop()
+
+		case code.OpIter:
+			iterable := self.pop()
+
+			elements, ok := object.Iterate(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+
+			err := self.push(&object.Iterator{Elements: elements})
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			iterator, ok := self.stack[self.sp - 1].(*object.Iterator)
+			if !ok {
+				return fmt.Errorf("advancing non-iterator")
+			}
+
+			err := self.advanceIterator(iterator)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self *VM) executeCall(numArgs int) error {
+	callee := self.stack[self.sp - 1 - numArgs]
+	switch callee := callee.(type) {
+	case nil:
+		return fmt.Errorf("calling an uninitialized value")
+	case *object.Closure:
+		return self.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return self.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+func (self *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	if self.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow: more than %d nested calls", MaxFrames)
+	}
+
+	if self.sp - numArgs + cl.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	frame := NewFrame(cl, self.sp - numArgs)
+	self.pushFrame(frame)
+
+	// locals other than the arguments start out uninitialized, not with
+	// whatever an earlier call left in their slots
+	for i := self.sp ; i < frame.basePointer + cl.Fn.NumLocals ; i++ {
+		self.stack[i] = nil
+	}
+	self.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+func (self *VM) pushClosure(constIndex int, numFree int) error {
+	constant := self.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	// free variables live in cells; a value captured as is, like the
+	// enclosing function itself, gets a cell of its own
+	free := make([]object.Object, numFree)
+	for i := 0 ; i < numFree ; i++ {
+		value := self.stack[self.sp - numFree + i]
+		if _, ok := value.(*object.Cell); !ok {
+			value = &object.Cell{Value: value}
 		}
+		free[i] = value
+	}
+	self.sp = self.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return self.push(closure)
+}
+
+func (self *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := self.stack[self.sp - numArgs : self.sp]
+
+	result := builtin.Fn(args...)
+	self.sp = self.sp - numArgs - 1
+
+	if err, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+
+	if result != nil {
+		self.push(result)
+	} else {
+		self.push(Null)
 	}
 
 	return nil
 }
 
+// advanceIterator pushes the next element of the iterator on top of the
+// stack followed by true, or replaces the exhausted iterator with false.
+func (self *VM) advanceIterator(iterator *object.Iterator) error {
+	element, ok := iterator.Next()
+	if !ok {
+		self.pop()
+		return self.push(False)
+	}
+
+	err := self.push(element)
+	if err != nil {
+		return err
+	}
+
+	return self.push(True)
+}
+
 func (self *VM) push(o object.Object) error {
 	if self.sp >= StackSize {
 		return fmt.Errorf("stack overflow")
@@ -289,13 +570,13 @@ func (self *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return self.executeBinaryIntegerOperation(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return self.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return self.executeBinaryStringOperation(op, left, right)
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+		return operatorError(op, left, right)
 	}
-	
-	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
 }
 
 func (self *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
@@ -303,6 +584,46 @@ func (self *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object
 	rightValue := right.(*object.Integer).Value
 
 	var result int64
+	var overflow bool
+
+	switch op {
+	case code.OpAdd:
+		result, overflow = object.AddInt(leftValue, rightValue)
+	case code.OpSub:
+		result, overflow = object.SubInt(leftValue, rightValue)
+	case code.OpMul:
+		result, overflow = object.MulInt(leftValue, rightValue)
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result, overflow = object.DivInt(leftValue, rightValue)
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		result = leftValue % rightValue
+	case code.OpPow:
+		if rightValue < 0 {
+			return self.push(&object.Float{Value: math.Pow(float64(leftValue), float64(rightValue))})
+		}
+		result, overflow = object.PowInt(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	if overflow && self.CheckOverflow {
+		return fmt.Errorf("integer overflow: %d %s %d", leftValue, operators[op], rightValue)
+	}
+
+	return self.push(&object.Integer{Value: result})
+}
+
+func (self *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
+
+	var result float64
 
 	switch op {
 	case code.OpAdd:
@@ -313,11 +634,15 @@ func (self *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object
 		result = leftValue * rightValue
 	case code.OpDiv:
 		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	case code.OpPow:
+		result = math.Pow(leftValue, rightValue)
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return fmt.Errorf("unknown float operator: %d", op)
 	}
 
-	return self.push(&object.Integer{Value: result})
+	return self.push(&object.Float{Value: result})
 }
 
 func (self *VM) executeComparison(op code.Opcode) error {
@@ -328,13 +653,21 @@ func (self *VM) executeComparison(op code.Opcode) error {
 		return self.executeIntegerComparison(op, left, right)
 	}
 
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return self.executeFloatComparison(op, left, right)
+	}
+
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return self.executeStringComparison(op, left, right)
+	}
+
 	switch op {
 	case code.OpEqual:
 		return self.push(nativeBoolToBooleanObject(right == left))
 	case code.OpNotEqual:
 		return self.push(nativeBoolToBooleanObject(right != left))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return operatorError(op, left, right)
 	}
 }
 
@@ -349,11 +682,85 @@ func (self *VM) executeIntegerComparison(op code.Opcode, left, right object.Obje
 		return self.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return self.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return self.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (self *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := object.ToFloat(left)
+	rightValue := object.ToFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return self.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return self.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	case code.OpGreaterThan:
+		return self.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return self.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
+func (self *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return self.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return self.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return self.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+var operators = map[code.Opcode]string{
+	code.OpAdd: 			"+",
+	code.OpSub: 			"-",
+	code.OpMul: 			"*",
+	code.OpDiv: 			"/",
+	code.OpMod: 			"%",
+	code.OpPow: 			"**",
+	code.OpEqual: 			"==",
+	code.OpNotEqual: 		"!=",
+	code.OpGreaterThan: 	">",
+	code.OpGreaterEqual: 	">=",
+	code.OpLessThan: 		"<",
+	code.OpLessEqual: 		"<=",
+}
+
+// operatorError reports a binary operator applied to operands it does not
+// support, worded like the evaluator's errors.
+func operatorError(op code.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -379,12 +786,14 @@ func (self *VM) executeBangOperator() error {
 func (self *VM) executeMinusOperator() error {
 	operand := self.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return self.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return self.push(&object.Float{Value: -operand.Value})
+	default:
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return self.push(&object.Integer{Value: -value})
 }
 
 func isTruthy(obj object.Object) bool {
@@ -406,7 +815,7 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 
 func (self *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return operatorError(op, left, right)
 	}
 
 	leftValue := left.(*object.String).Value
@@ -470,6 +879,35 @@ func (self *VM) executeArrayIndex(array, index object.Object) error {
 	return self.push(arrayObject.Elements[i])
 }
 
+func (self *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("array index out of range: %d (length %d)", i.Value, len(left.Elements))
+		}
+
+		left.Elements[i.Value] = value
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return self.push(value)
+}
+
 func (self *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
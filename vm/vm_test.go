@@ -7,6 +7,7 @@ import (
 	"bear/object"
 	"bear/parser"
 	"bear/compiler"
+	"math"
 	"testing"
 )
 
@@ -27,6 +28,11 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("compiler error: %s", err)
 		}
 
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("verify error: %s", err)
+		}
+
 		vm := New(comp.Bytecode())
 		err = vm.Run()
 		if err != nil {
@@ -70,6 +76,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -84,6 +95,16 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testStringObject failed: %s", err)
 		}
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error: %T (%+v)", actual, actual)
+			return
+		}
+
+		if errObj.Message != expected.Message {
+			t.Errorf("wrong error message. expected=%q, got=%q", expected.Message, errObj.Message)
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
@@ -147,6 +168,15 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"3 ** 0", 1},
+		{"2 ** -1", 0.5},
+		{"7.5 % 2", 1.5},
+		{"2.0 ** 0.5 * 2.0 ** 0.5 > 1.99", true},
 	}
 
 	runVmTests(t, tests)
@@ -191,6 +221,21 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false) { 5; })", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"1.5 >= 1", true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"a" <= "a"`, true},
+		{`"b" >= "a"`, true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
 	}
 
 	runVmTests(t, tests)
@@ -329,6 +374,14 @@ func TestFunctionsWithReturnStatement(t *testing.T) {
 		`,
 			expected: 99,
 		},
+		{
+			input: `
+		let x = 5;
+		return x * 2;
+		100;
+		`,
+			expected: 10,
+		},
 	}
 
 	runVmTests(t, tests)
@@ -422,4 +475,836 @@ func TestCallingFunctionsWithBindings(t *testing.T) {
 	}
 
 	runVmTests(t, tests)
-}
\ No newline at end of file
+}
+
+func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+		let identity = fn(a) { a; };
+		identity(4);
+		`,
+			expected: 4,
+		},
+		{
+			input: `
+		let sum = fn(a, b) { a + b; };
+		sum(1, 2);
+		`,
+			expected: 3,
+		},
+		{
+			input: `
+		let sum = fn(a, b) {
+			let c = a + b;
+			c;
+		};
+		sum(1, 2);
+		`,
+			expected: 3,
+		},
+		{
+			input: `
+		let sum = fn(a, b) {
+			let c = a + b;
+			c;
+		};
+		sum(1, 2) + sum(3, 4);
+		`,
+			expected: 10,
+		},
+		{
+			input: `
+		let sum = fn(a, b) {
+			let c = a + b;
+			c;
+		};
+		let outer = fn() {
+			sum(1, 2) + sum(3, 4);
+		};
+		outer();
+		`,
+			expected: 10,
+		},
+		{
+			input: `
+		let globalNum = 10;
+
+		let sum = fn(a, b) {
+			let c = a + b;
+			c + globalNum;
+		};
+
+		let outer = fn() {
+			sum(1, 2) + sum(3, 4) + globalNum;
+		};
+
+		outer() + globalNum;
+		`,
+			expected: 50,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `fn() { 1; }(1);`,
+			expected: `1:12: wrong number of arguments: want=0, got=1`,
+		},
+		{
+			input:    `fn(a) { a; }();`,
+			expected: `1:13: wrong number of arguments: want=1, got=0`,
+		},
+		{
+			input:    `fn(a, b) { a + b; }(1);`,
+			expected: `1:20: wrong number of arguments: want=2, got=1`,
+		},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("verify error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != test.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", test.expected, err)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`tail([1, 2, 3])`, []int{2, 3}},
+		{`tail([])`, Null},
+		{`push([], 1)`, []int{1}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"len(1)", "1:4: argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "1:4: wrong number of arguments. got=2, want=1"},
+		{"first(1)", "1:6: argument to `first` must be ARRAY, got INTEGER"},
+		{"last(1)", "1:5: argument to `last` must be ARRAY, got INTEGER"},
+		{"let f = fn() { push(1, 1) };\nf()", "1:20: argument to `push` must be ARRAY, got INTEGER"},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("verify error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", test.input)
+		}
+
+		if err.Error() != test.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", test.expected, err)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+		let newClosure = fn(a) {
+			fn() { a; };
+		};
+		let closure = newClosure(99);
+		closure();
+		`,
+			expected: 99,
+		},
+		{
+			input: `
+		let newAdder = fn(a, b) {
+			fn(c) { a + b + c };
+		};
+		let adder = newAdder(1, 2);
+		adder(8);
+		`,
+			expected: 11,
+		},
+		{
+			input: `
+		let newAdder = fn(a, b) {
+			let c = a + b;
+			fn(d) { c + d };
+		};
+		let adder = newAdder(1, 2);
+		adder(8);
+		`,
+			expected: 11,
+		},
+		{
+			input: `
+		let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) {
+				let e = d + c;
+				fn(f) { e + f; };
+			};
+		};
+		let newAdderInner = newAdderOuter(1, 2)
+		let adder = newAdderInner(3);
+		adder(8);
+		`,
+			expected: 14,
+		},
+		{
+			input: `
+		let a = 1;
+		let newAdderOuter = fn(b) {
+			fn(c) {
+				fn(d) { a + b + c + d };
+			};
+		};
+		let newAdderInner = newAdderOuter(2)
+		let adder = newAdderInner(3);
+		adder(8);
+		`,
+			expected: 14,
+		},
+		{
+			input: `
+		let newClosure = fn(a, b) {
+			let one = fn() { a; };
+			let two = fn() { b; };
+			fn() { one() + two(); };
+		};
+		let closure = newClosure(9, 90);
+		closure();
+		`,
+			expected: 99,
+		},
+		{
+			input: `
+		let curry = fn(x) { fn(y) { x * y } };
+		let double = curry(2);
+		double(21);
+		`,
+			expected: 42,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+		let countDown = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				countDown(x - 1);
+			}
+		};
+		countDown(1);
+		`,
+			expected: 0,
+		},
+		{
+			input: `
+		let countDown = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				countDown(x - 1);
+			}
+		};
+		let wrapper = fn() {
+			countDown(1);
+		};
+		wrapper();
+		`,
+			expected: 0,
+		},
+		{
+			input: `
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+		};
+		wrapper();
+		`,
+			expected: 0,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+		let fibonacci = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				if (x == 1) {
+					return 1;
+				} else {
+					fibonacci(x - 1) + fibonacci(x - 2);
+				}
+			}
+		};
+		fibonacci(15);
+		`,
+			expected: 610,
+		},
+		{
+			input: `
+		let wrapper = fn(n) {
+			let fib = fn(x) {
+				if (x < 2) { return x; }
+				fib(x - 1) + fib(x - 2);
+			};
+			fib(n);
+		};
+		wrapper(15);
+		`,
+			expected: 610,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+		let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		[isEven(10), isOdd(10)];
+		isEven(7);
+		`,
+			expected: false,
+		},
+		{
+			input: `
+		let wrapper = fn(x) {
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(x);
+		};
+		wrapper(10);
+		`,
+			expected: true,
+		},
+		{
+			input: `
+		let wrapper = fn(x) {
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let check = fn() { isOdd(x) };
+			check();
+		};
+		wrapper(7);
+		`,
+			expected: true,
+		},
+		{
+			input: `
+		let wrapper = fn() {
+			let fns = [fn() { later() }];
+			let later = fn() { 7 };
+			fns[0]();
+		};
+		wrapper();
+		`,
+			expected: 7,
+		},
+		{
+			input: `
+		let wrapper = fn() {
+			let g = fn() { 7 };
+			let f = fn() { g() };
+			let g = fn() { 8 };
+			f();
+		};
+		wrapper();
+		`,
+			expected: 8,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUninitializedVariables(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { g() }; f(); let g = fn() { 1 };", "1:16: uninitialized variable"},
+		{"let f = fn() { let h = fn() { g() }; h(); let g = fn() { 1 }; }; f()", "1:31: uninitialized variable"},
+		{"let f = fn() { g(); let g = fn() { 1 }; }; f()", "1:16: uninitialized variable"},
+		{"if (false) { let x = 1; }; x", "1:28: uninitialized variable"},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", test.input)
+		}
+
+		if err.Error() != test.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", test.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+	x + true
+};
+let outer = fn() { inner(1) };
+outer();`
+
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expectedMessage := "type mismatch: INTEGER + BOOLEAN"
+	if runtimeErr.Message != expectedMessage {
+		t.Errorf("wrong message. want=%q, got=%q", expectedMessage, runtimeErr.Message)
+	}
+
+	expectedTrace := []struct{
+		function string
+		pos 	 string
+	}{
+		{"inner", "2:4"},
+		{"outer", "4:25"},
+		{"<main>", "5:6"},
+	}
+
+	if len(runtimeErr.Trace) != len(expectedTrace) {
+		t.Fatalf("wrong trace length. want=%d, got=%d", len(expectedTrace), len(runtimeErr.Trace))
+	}
+
+	for i, want := range expectedTrace {
+		frame := runtimeErr.Trace[i]
+		if frame.Function != want.function {
+			t.Errorf("trace[%d] has wrong function. want=%q, got=%q", i, want.function, frame.Function)
+		}
+		if frame.Pos.String() != want.pos {
+			t.Errorf("trace[%d] has wrong position. want=%s, got=%s", i, want.pos, frame.Pos)
+		}
+	}
+
+	expectedBacktrace := `2:4: type mismatch: INTEGER + BOOLEAN
+    at inner (2:4) ip=0003
+    at outer (4:25) ip=0006
+    at <main> (5:6) ip=0017
+`
+	if runtimeErr.Backtrace() != expectedBacktrace {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expectedBacktrace, runtimeErr.Backtrace())
+	}
+}
+
+func TestCallDepthExceeded(t *testing.T) {
+	program := parse(`let f = fn() { f() }; f();`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expected := fmt.Sprintf("stack overflow: more than %d nested calls", MaxFrames)
+	if runtimeErr.Message != expected {
+		t.Errorf("wrong message. want=%q, got=%q", expected, runtimeErr.Message)
+	}
+
+	if len(runtimeErr.Trace) != MaxFrames {
+		t.Errorf("wrong trace length. want=%d, got=%d", MaxFrames, len(runtimeErr.Trace))
+	}
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+
+	return nil
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"int(3.9)", 3},
+		{`int("42")`, 42},
+		{"float(3)", 3.0},
+		{`float("2.5")`, 2.5},
+		{"let avg = fn(a, b) { (a + b) / 2.0 }; avg(3, 4)", 3.5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 5) { let i = i + 1; }; i", 5},
+		{"while (false) { 1; }; 2", 2},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{`let s = ""; for (c in "abc") { let s = c + s; }; s`, "cba"},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; }; s`, "abc"},
+		{"for (x in []) { 1; }; 2", 2},
+		{"let i = 0; while (true) { if (i == 3) { break; } let i = i + 1; }; i", 3},
+		{"let odd = 0; for (x in [1, 2, 3, 4, 5]) { if (x - (x / 2) * 2 == 0) { continue; } let odd = odd + x; }; odd", 9},
+		{"let n = 0; for (a in [1, 2, 3]) { for (b in [1, 2, 3]) { if (b == 2) { break; } let n = n + 1; } }; n", 3},
+		{"let find = fn(xs, y) { for (x in xs) { if (x == y) { return true; } } false }; find([1, 2, 3], 2)", true},
+		{"let find = fn(xs, y) { for (x in xs) { if (x == y) { return true; } } false }; find([1, 2, 3], 4)", false},
+		{"let count = fn() { let i = 0; while (i < 3) { let i = i + 1; } i }; count()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoopOverLargeArray(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let xs = [];
+			let i = 0;
+			while (i < 10000) {
+				let xs = push(xs, i);
+				let i = i + 1;
+			}
+
+			let sum = 0;
+			for (x in xs) {
+				if (x == 0) { let unused = x; }
+				let sum = sum + x;
+			}
+			sum;
+			`,
+			expected: 49995000,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIteratingNonIterable(t *testing.T) {
+	program := parse("for (x in 5) { x; }")
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	expected := "1:11: cannot iterate over INTEGER"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { sum += x; }; sum", 10},
+		{"let i = 0; while (i < 10) { i += 1; }; i", 10},
+		{"let f = fn() { let x = 1; x += 1; x }; f()", 2},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{
+			`
+			let counter = fn() { let c = 0; fn() { c += 1; c } };
+			let next = counter();
+			next(); next(); next()
+			`,
+			3,
+		},
+		{"let mk = fn() { let c = 0; let inc = fn() { c += 1; c }; inc(); inc(); c }; mk()", 2},
+		{"let x = 1; let f = fn() { x = 10; 1 }; x += f(); x", 2},
+		{
+			`
+			let pair = fn() { let c = 0; [fn() { c += 1 }, fn() { c }] };
+			let fns = pair();
+			fns[0](); fns[0](); fns[1]()
+			`,
+			2,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 2", true},
+		{`"" || false`, true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"false && 1 / 0 == 1", false},
+		{"let x = 0; let bump = fn() { x += 1; true }; false && bump(); true || bump(); x", 0},
+		{"let x = 0; let bump = fn() { x += 1; true }; true && bump(); false || bump(); x", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[1] = 5; a", []int{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[0] = 9", 9},
+		{"let a = [1, 2, 3]; a[2] *= 10; a[2]", 30},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": 1}; h["a"] += 1; h["a"]`, 2},
+		{"let grid = [[0, 0], [0, 0]]; grid[1][0] = 7; grid[1]", []int{7, 0}},
+		{"let a = [1, 2]; let b = a; b[0] = 3; a[0]", 3},
+		{
+			`
+			let counts = {};
+			for (c in "abracadabra") {
+				if (!counts[c]) { counts[c] = 0; }
+				counts[c] += 1;
+			}
+			counts["a"] * 10 + counts["b"]
+			`,
+			52,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2]; a[2] = 3", "1:22: array index out of range: 2 (length 2)"},
+		{"let a = [1, 2]; a[-1] = 3", "1:23: array index out of range: -1 (length 2)"},
+		{`let a = [1, 2]; a["x"] = 3`, "1:24: array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn() {}] = 1", "1:24: unusable as hash key: CLOSURE"},
+		{`let s = "ab"; s[0] = "c"`, "1:20: index assignment not supported: STRING"},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("verify error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != test.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", test.expected, err)
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct{
+		input 			string
+		checkOverflow 	bool
+		expected 		string
+	}{
+		{"1 / 0", false, "1:3: division by zero"},
+		{"let x = 5; x % (2 - 2)", false, "1:14: modulo by zero"},
+		{"let f = fn(n) { n / 0 }; f(1)", false, "1:19: division by zero"},
+		{"let x = 1; x /= 0", false, "1:14: division by zero"},
+		{"9223372036854775807 + 1", true, "1:21: integer overflow: 9223372036854775807 + 1"},
+		{"0 - 9223372036854775807 - 2", true, "1:25: integer overflow: -9223372036854775807 - 2"},
+		{"4294967296 * 4294967296", true, "1:12: integer overflow: 4294967296 * 4294967296"},
+		{"2 ** 64", true, "1:3: integer overflow: 2 ** 64"},
+	}
+
+	for _, test := range tests {
+		program := parse(test.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("verify error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.CheckOverflow = test.checkOverflow
+		err = vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", test.input)
+			continue
+		}
+
+		if err.Error() != test.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", test.expected, err)
+		}
+	}
+}
+
+func TestUncheckedOverflowWraps(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", math.MinInt64},
+		{"2 ** 64", 0},
+		{"1.0 / 0 > 1000000", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+// BenchmarkLoop runs a tight loop compiled with and without the
+// compiler's optimizations.
+func BenchmarkLoop(b *testing.B) {
+	input := `
+	let count = fn(n) {
+		let total = 0;
+		let i = 0;
+		while (i < n && !(total < 0)) {
+			if (i % 3 == 0) { total += i; } else { total -= 1; }
+			i += 1;
+		}
+		total
+	};
+	count(10000);
+	`
+
+	for _, optimize := range []bool{false, true} {
+		b.Run(fmt.Sprintf("optimize=%t", optimize), func(b *testing.B) {
+			comp := compiler.New()
+			comp.Optimize = optimize
+			if err := comp.Compile(parse(input)); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+
+			for i := 0 ; i < b.N ; i++ {
+				if err := New(bytecode).Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}